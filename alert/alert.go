@@ -0,0 +1,171 @@
+package alert
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/gh-efforts/rbot/webhook"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("alert")
+
+// kinds of notification
+const (
+	// the provider was passing and its rate dropped under the threshold
+	KindFailing = "failing"
+	// the provider is still under the threshold after the cool-down
+	KindLowRate = "low-rate"
+	// the provider was failing and its rate is back over the threshold
+	KindRecovered = "recovered"
+)
+
+type Alert struct {
+	repo *repo.Repo
+}
+
+// Notification is posted to the webhooks of the json format as it is
+type Notification struct {
+	Kind      string    `json:"kind"`
+	Provider  string    `json:"provider"`
+	RunID     int64     `json:"runID"`
+	Tested    int       `json:"tested"`
+	Succeeded int       `json:"succeeded"`
+	Rate      float64   `json:"rate"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+	Text      string    `json:"text"`
+}
+
+// rate is the outcome of the deals of a provider in a run
+type rate struct {
+	provider  string
+	tested    int
+	succeeded int
+}
+
+func (r rate) value() float64 {
+	return float64(r.succeeded) / float64(r.tested)
+}
+
+func New(repo *repo.Repo) *Alert {
+	return &Alert{
+		repo: repo,
+	}
+}
+
+// Check judges the success rate of each provider in the run against the threshold,
+// and notifies the webhooks of the providers which fail, keep failing or recover
+func (a *Alert) Check(ctx context.Context, runID int64) {
+	conf := a.repo.Conf().Alert
+	if conf.Threshold <= 0 {
+		return
+	}
+
+	rates, err := a.rates(ctx, runID)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, r := range rates {
+		if r.tested < conf.MinTested {
+			continue
+		}
+		n, err := a.judge(ctx, runID, r)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if n == nil {
+			continue
+		}
+		log.Infow("alert", "kind", n.Kind, "provider", n.Provider, "rate", n.Rate, "runID", runID)
+		a.notify(ctx, n)
+	}
+}
+
+// rates counts the deals of each provider which succeeded on their last attempt in the run
+func (a *Alert) rates(ctx context.Context, runID int64) ([]rate, error) {
+	rows, err := a.repo.DB.QueryContext(ctx, `SELECT provider, COUNT(*), SUM(CASE WHEN `+repo.Succeeded+` THEN 1 ELSE 0 END) FROM Attempts a
+		WHERE run_id=$1 AND attempt = (SELECT MAX(attempt) FROM Attempts WHERE run_id=a.run_id AND deal_id=a.deal_id)
+		GROUP BY provider`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []rate{}
+	for rows.Next() {
+		var r rate
+		err := rows.Scan(&r.provider, &r.tested, &r.succeeded)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// judge updates the state of the provider with its rate, and returns the notification to send if any
+func (a *Alert) judge(ctx context.Context, runID int64, r rate) (*Notification, error) {
+	conf := a.repo.Conf().Alert
+
+	// an unknown provider is taken as passing, so its first failure is notified
+	wasPassing := true
+	var lastAlert sql.NullTime
+	err := a.repo.DB.QueryRowContext(ctx, `SELECT passing, last_alert FROM AlertStates WHERE provider=$1`, r.provider).Scan(&wasPassing, &lastAlert)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	passing := r.value() >= conf.Threshold
+	kind := ""
+	switch {
+	case passing && !wasPassing:
+		kind = KindRecovered
+	case !passing && wasPassing:
+		kind = KindFailing
+	case !passing && (!lastAlert.Valid || now.Sub(lastAlert.Time) >= time.Duration(conf.Cooldown)):
+		kind = KindLowRate
+	}
+	if kind != "" {
+		lastAlert = sql.NullTime{Time: now, Valid: true}
+	}
+
+	_, err = a.repo.DB.ExecContext(ctx, `INSERT or REPLACE INTO AlertStates (provider, passing, rate, last_alert, last_update) VALUES ($1, $2, $3, $4, $5)`,
+		r.provider, passing, r.value(), lastAlert, now)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "" {
+		return nil, nil
+	}
+
+	n := &Notification{
+		Kind:      kind,
+		Provider:  r.provider,
+		RunID:     runID,
+		Tested:    r.tested,
+		Succeeded: r.succeeded,
+		Rate:      r.value(),
+		Threshold: conf.Threshold,
+		Time:      now,
+	}
+	n.Text = fmt.Sprintf("rbot: %s %s, %d/%d deals retrieved (%.0f%%, threshold %.0f%%) in run %d",
+		n.Provider, n.Kind, n.Succeeded, n.Tested, n.Rate*100, n.Threshold*100, n.RunID)
+	return n, nil
+}
+
+func (a *Alert) notify(ctx context.Context, n *Notification) {
+	for _, wh := range a.repo.Conf().Alert.Webhooks {
+		err := webhook.Post(ctx, wh, n.Text, n)
+		if err != nil {
+			log.Errorw("webhook", "url", wh.URL, "err", err)
+		}
+	}
+}
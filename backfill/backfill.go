@@ -3,14 +3,18 @@ package backfill
 import (
 	"bufio"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/gh-efforts/rbot/repo"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/klauspost/compress/zstd"
@@ -18,91 +22,174 @@ import (
 
 var log = logging.Logger("backfill")
 
+type lotusApi interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+}
+
 type Backfill struct {
-	repo      *repo.Repo
-	providers map[string]struct{}
+	repo     *repo.Repo
+	lotusApi lotusApi
+	// closed by Stop, the backfill in progress stops after the deal being inserted
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 type Filter struct {
-	Providers  []string `json:"providers"`
-	StartEpoch int      `json:"startEpoch"`
+	Providers    []string `json:"providers"`
+	Clients      []string `json:"clients"`
+	VerifiedOnly bool     `json:"verifiedOnly"`
+	// StartEpoch and StartEpochMax bound the start epoch of the deals, no upper bound if zero
+	StartEpoch    int `json:"startEpoch"`
+	StartEpochMax int `json:"startEpochMax"`
+	// ActiveAt keeps only deals whose [StartEpoch, EndEpoch) covers the height
+	ActiveAt     int   `json:"activeAt"`
+	MinPieceSize int64 `json:"minPieceSize"`
+	// SampleRate keeps each matched deal with the given probability within [0, 1], all if nil,
+	// SampleRates overrides it per provider, 0 excludes the provider
+	SampleRate  *float64           `json:"sampleRate,omitempty"`
+	SampleRates map[string]float64 `json:"sampleRates"`
+	DryRun      bool               `json:"dryRun"`
+	// IncludeInactive also inserts slashed, expired and never activated deals,
+	// they are stored with their state and never selected for retrieval
+	IncludeInactive bool `json:"includeInactive"`
 }
 
-func New(repo *repo.Repo) *Backfill {
-	b := &Backfill{
-		repo:      repo,
-		providers: map[string]struct{}{},
-	}
+type Result struct {
+	DryRun    bool           `json:"dryRun"`
+	Scanned   int            `json:"scanned"`
+	Matched   int            `json:"matched"`
+	Inserted  int            `json:"inserted"`
+	Providers map[string]int `json:"providers"`
+	// Skipped counts the deals of our providers that could never be retrieved, by reason
+	Skipped map[string]int `json:"skipped"`
+	// Interrupted tells the backfill was stopped by a shutdown before the end of the file,
+	// the counts cover the deals scanned until then
+	Interrupted bool `json:"interrupted"`
+}
 
-	for _, p := range repo.Conf.Providers {
-		b.providers[p] = struct{}{}
+func New(repo *repo.Repo, lotusApi lotusApi) *Backfill {
+	b := &Backfill{
+		repo:     repo,
+		lotusApi: lotusApi,
+		stop:     make(chan struct{}),
 	}
 
 	return b
 }
 
+// Stop makes the backfill in progress return what it inserted so far, and refuses new ones
+func (b *Backfill) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
 func (b *Backfill) Fill(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-b.stop:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
 	var f Filter
 	err := json.NewDecoder(r.Body).Decode(&f)
+	if err == nil {
+		err = f.check()
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	err = b.parse(r.Context(), &f)
+	res, err := b.parse(r.Context(), &f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
-func (b *Backfill) parse(ctx context.Context, f *Filter) error {
+func (b *Backfill) parse(ctx context.Context, f *Filter) (*Result, error) {
 	providers := map[string]struct{}{}
 	for _, p := range f.Providers {
 		providers[p] = struct{}{}
 	}
 	if len(providers) == 0 {
-		providers = b.providers
+		// the tracked providers, as of now since they can change at runtime
+		tracked, err := b.repo.TrackedProviders(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range tracked {
+			providers[p] = struct{}{}
+		}
 	}
-	log.Debugw("filter", "providers", providers, "start-epoch", f.StartEpoch, "path", b.repo.StorageMarketDealFile())
+	clients := map[string]struct{}{}
+	for _, c := range f.Clients {
+		clients[c] = struct{}{}
+	}
+	log.Debugw("filter", "providers", providers, "clients", f.Clients, "verified-only", f.VerifiedOnly, "start-epoch", f.StartEpoch,
+		"start-epoch-max", f.StartEpochMax, "active-at", f.ActiveAt, "min-piece-size", f.MinPieceSize, "sample-rate", f.SampleRate, "sample-rates", f.SampleRates, "dry-run", f.DryRun, "path", b.repo.StorageMarketDealFile())
+	head, err := b.lotusApi.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("chain head: %w", err)
+	}
+	height := head.Height()
+
 	file, err := os.Open(b.repo.StorageMarketDealFile())
 	if err != nil {
-		return fmt.Errorf("open: %w", err)
+		return nil, fmt.Errorf("open: %w", err)
 	}
 	defer file.Close()
 
 	dec, err := zstd.NewReader(file)
 	if err != nil {
-		return fmt.Errorf("zstd NewReader: %w", err)
+		return nil, fmt.Errorf("zstd NewReader: %w", err)
 	}
 	defer dec.Close()
 
 	decoder := json.NewDecoder(bufio.NewReader(dec))
 
-	head, err := decoder.Token()
+	tok, err := decoder.Token()
 	if err != nil {
-		return fmt.Errorf("decoder head: %w", err)
+		return nil, fmt.Errorf("decoder head: %w", err)
 	}
 
-	if delim, ok := head.(json.Delim); !ok || delim != '{' {
-		return fmt.Errorf("head expected {, got %v", delim)
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("head expected {, got %v", delim)
 	}
 
+	// the writes are batched in transactions, the deals of a batch are lost if the scan fails
+	bt := &batch{db: b.repo.DB}
+	defer bt.rollback()
+	updated := 0
+
+	res := &Result{
+		DryRun:    f.DryRun,
+		Providers: map[string]int{},
+		Skipped:   map[string]int{},
+	}
 	for decoder.More() {
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("context done")
+			return nil, fmt.Errorf("context done")
+		case <-b.stop:
+			res.Interrupted = true
+			log.Warnw("backfill interrupted", "scanned", res.Scanned, "matched", res.Matched, "inserted", res.Inserted, "updated", updated)
+			return res, bt.commit()
 		default:
 		}
 		var dealID int64
 		t, err := decoder.Token()
 		if err != nil {
-			return fmt.Errorf("reading JSON key: %w", err)
+			return nil, fmt.Errorf("reading JSON key: %w", err)
 		}
 
 		var deal api.MarketDeal
 		if err := decoder.Decode(&deal); err != nil {
-			return fmt.Errorf("decode deal: %w", err)
+			return nil, fmt.Errorf("decode deal: %w", err)
 		}
+		res.Scanned++
 
 		key := t.(string)
 		dealID, err = strconv.ParseInt(key, 10, 64)
@@ -111,26 +198,174 @@ func (b *Backfill) parse(ctx context.Context, f *Filter) error {
 			continue
 		}
 
-		if _, ok := providers[deal.Proposal.Provider.String()]; !ok {
-			//log.Debugf("%s not our providers", deal.Proposal.Provider.String())
+		provider := deal.Proposal.Provider.String()
+		if _, ok := providers[provider]; !ok {
+			//log.Debugf("%s not our providers", provider)
 			continue
 		}
-		if deal.Proposal.StartEpoch < abi.ChainEpoch(f.StartEpoch) {
+		if !f.DryRun {
+			// refresh the state of the deal if tracked, eg: inserted before the state columns existed,
+			// or inactive since, so it is no longer retrieved
+			ret, err := bt.exec(ctx, `UPDATE Deals SET sector_start_epoch=$1, last_updated_epoch=$2, slash_epoch=$3 WHERE deal_id=$4`,
+				deal.State.SectorStartEpoch, deal.State.LastUpdatedEpoch, deal.State.SlashEpoch, dealID)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := ret.RowsAffected(); err == nil {
+				updated += int(n)
+			}
+		}
+		if len(clients) != 0 {
+			if _, ok := clients[deal.Proposal.Client.String()]; !ok {
+				continue
+			}
+		}
+		if !f.match(&deal) {
 			continue
 		}
 		label, err := deal.Proposal.Label.ToString()
 		if err != nil {
-			log.Debugf("deal: %d lable can not to string", label)
+			log.Debugf("deal: %d lable can not to string", dealID)
 			continue
 		}
+		if reason := inactive(&deal, height); reason != "" {
+			res.Skipped[reason]++
+			if !f.IncludeInactive {
+				continue
+			}
+		}
+		if !f.sample(provider) {
+			continue
+		}
+
+		res.Matched++
+		res.Providers[provider]++
+		if f.DryRun {
+			continue
+		}
+
+		ret, err := bt.exec(ctx, `INSERT or IGNORE INTO Deals (deal_id, payload_cid, client, provider, start_epoch, end_epoch, sector_start_epoch, last_updated_epoch, slash_epoch) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			dealID, label, deal.Proposal.Client.String(), provider, deal.Proposal.StartEpoch, deal.Proposal.EndEpoch, deal.State.SectorStartEpoch, deal.State.LastUpdatedEpoch, deal.State.SlashEpoch)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := ret.RowsAffected(); err == nil {
+			res.Inserted += int(n)
+		}
+		log.Infow("backfill deal", "dealID", dealID, "client", deal.Proposal.Client.String(), "provider", provider, "label", label)
+	}
+
+	err = bt.commit()
+	if err != nil {
+		return nil, err
+	}
+	log.Infow("backfill done", "dry-run", res.DryRun, "scanned", res.Scanned, "matched", res.Matched, "inserted", res.Inserted, "skipped", res.Skipped, "updated", updated)
+	return res, nil
+}
+
+// batchSize is the number of writes of a transaction, the DB has a single connection
+// which the other writers wait for
+const batchSize = 1000
+
+// batch runs the writes in transactions of batchSize writes
+type batch struct {
+	db *sql.DB
+	tx *sql.Tx
+	n  int
+}
 
-		_, err = b.repo.DB.ExecContext(ctx, `INSERT or IGNORE INTO Deals (deal_id, payload_cid, client, provider, start_epoch, end_epoch) VALUES ($1, $2, $3, $4, $5, $6)`,
-			dealID, label, deal.Proposal.Client.String(), deal.Proposal.Provider.String(), deal.Proposal.StartEpoch, deal.Proposal.EndEpoch)
+func (bt *batch) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if bt.tx == nil {
+		tx, err := bt.db.BeginTx(ctx, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		log.Infow("backfill deal", "dealID", dealID, "client", deal.Proposal.Client.String(), "provider", deal.Proposal.Provider.String(), "label", label)
+		bt.tx = tx
+	}
+	ret, err := bt.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	bt.n++
+	if bt.n >= batchSize {
+		return ret, bt.commit()
+	}
+	return ret, nil
+}
+
+func (bt *batch) commit() error {
+	if bt.tx == nil {
+		return nil
 	}
+	err := bt.tx.Commit()
+	bt.tx, bt.n = nil, 0
+	return err
+}
 
+func (bt *batch) rollback() {
+	if bt.tx != nil {
+		bt.tx.Rollback()
+		bt.tx, bt.n = nil, 0
+	}
+}
+
+// inactive returns why the deal could never be retrieved at the height, or empty if it could
+func inactive(deal *api.MarketDeal, height abi.ChainEpoch) string {
+	if deal.State.SlashEpoch != -1 {
+		return "slashed"
+	}
+	if deal.Proposal.EndEpoch <= height {
+		return "expired"
+	}
+	if deal.State.SectorStartEpoch == -1 {
+		if deal.Proposal.StartEpoch > height {
+			return "pending"
+		}
+		return "not-activated"
+	}
+	return ""
+}
+
+// match checks the proposal fields of the deal against the filter
+func (f *Filter) match(deal *api.MarketDeal) bool {
+	p := deal.Proposal
+	if f.VerifiedOnly && !p.VerifiedDeal {
+		return false
+	}
+	if p.StartEpoch < abi.ChainEpoch(f.StartEpoch) {
+		return false
+	}
+	if f.StartEpochMax > 0 && p.StartEpoch > abi.ChainEpoch(f.StartEpochMax) {
+		return false
+	}
+	if f.ActiveAt > 0 && (p.StartEpoch > abi.ChainEpoch(f.ActiveAt) || p.EndEpoch <= abi.ChainEpoch(f.ActiveAt)) {
+		return false
+	}
+	if int64(p.PieceSize) < f.MinPieceSize {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) check() error {
+	if f.SampleRate != nil && (*f.SampleRate < 0 || *f.SampleRate > 1) {
+		return fmt.Errorf("sample rate %v: not within [0, 1]", *f.SampleRate)
+	}
+	for p, rate := range f.SampleRates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("sample rate of %s %v: not within [0, 1]", p, rate)
+		}
+	}
 	return nil
 }
+
+func (f *Filter) sample(provider string) bool {
+	rate, ok := f.SampleRates[provider]
+	if !ok {
+		if f.SampleRate == nil {
+			return true
+		}
+		rate = *f.SampleRate
+	}
+	return rand.Float64() < rate
+}
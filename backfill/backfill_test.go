@@ -0,0 +1,149 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/gh-efforts/rbot/internal/fake"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/klauspost/compress/zstd"
+)
+
+const height = abi.ChainEpoch(10000)
+
+// newTestBackfill writes the deals as the market deals file of a new repo tracking f01000
+func newTestBackfill(t *testing.T, deals map[abi.DealID]*api.MarketDeal) *Backfill {
+	t.Helper()
+	dir := t.TempDir()
+	err := repo.Init(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.New(dir, map[string]string{
+		"lotus":     "eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http",
+		"providers": "f01000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	byKey := map[string]*api.MarketDeal{}
+	for id, d := range deals {
+		byKey[strconv.FormatUint(uint64(id), 10)] = d
+	}
+	raw, err := json.Marshal(byKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(r.StorageMarketDealFile(), enc.EncodeAll(raw, nil), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(r, fake.NewLotus(height))
+}
+
+func deal(t *testing.T, provider uint64, sectorStart, slash abi.ChainEpoch) *api.MarketDeal {
+	t.Helper()
+	d, err := fake.Deal(1001, provider, fake.RawCid([]byte(strconv.Itoa(int(sectorStart)))), height-100, height+1000, sectorStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.State.SlashEpoch = slash
+	return d
+}
+
+func TestInactiveStateOfTrackedDeals(t *testing.T) {
+	b := newTestBackfill(t, map[abi.DealID]*api.MarketDeal{
+		1: deal(t, 1000, height-50, height-10),
+		2: deal(t, 1000, height-50, height-10),
+		3: deal(t, 1000, height-50, -1),
+	})
+	_, err := b.repo.DB.Exec(`INSERT INTO Deals (deal_id, payload_cid, provider) VALUES (1, 'cid', 'f01000')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := b.parse(context.Background(), &Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Skipped["slashed"] != 2 || res.Inserted != 1 {
+		t.Fatalf("got %+v, want 2 slashed and 1 inserted", res)
+	}
+	var slash int64
+	err = b.repo.DB.QueryRow(`SELECT slash_epoch FROM Deals WHERE deal_id=1`).Scan(&slash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slash != int64(height-10) {
+		t.Fatalf("slash epoch of the tracked deal: got %d, want %d", slash, height-10)
+	}
+	var n int
+	err = b.repo.DB.QueryRow(`SELECT COUNT(*) FROM Deals WHERE deal_id=2`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("the slashed deal not tracked was inserted")
+	}
+}
+
+func TestActiveStateOfTrackedDeals(t *testing.T) {
+	b := newTestBackfill(t, map[abi.DealID]*api.MarketDeal{
+		1: deal(t, 1000, height-50, -1),
+	})
+	// tracked before the state columns existed
+	_, err := b.repo.DB.Exec(`INSERT INTO Deals (deal_id, payload_cid, provider) VALUES (1, 'cid', 'f01000')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.parse(context.Background(), &Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sectorStart, slash int64
+	err = b.repo.DB.QueryRow(`SELECT sector_start_epoch, slash_epoch FROM Deals WHERE deal_id=1`).Scan(&sectorStart, &slash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sectorStart != int64(height-50) || slash != -1 {
+		t.Fatalf("state of the active tracked deal: got sector start %d slash %d, want %d -1", sectorStart, slash, height-50)
+	}
+}
+
+func TestSampleRates(t *testing.T) {
+	none, half := 0.0, 0.5
+	cases := []struct {
+		name string
+		f    Filter
+		want bool
+	}{
+		{"unset keeps all", Filter{}, true},
+		{"zero keeps none", Filter{SampleRate: &none}, false},
+		{"provider zero excludes it", Filter{SampleRate: &half, SampleRates: map[string]float64{"f01000": 0}}, false},
+		{"provider one keeps all", Filter{SampleRate: &none, SampleRates: map[string]float64{"f01000": 1}}, true},
+	}
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			if c.f.sample("f01000") != c.want {
+				t.Fatalf("%s: got %v", c.name, !c.want)
+			}
+		}
+	}
+
+	bad := 1.5
+	if err := (&Filter{SampleRate: &bad}).check(); err == nil {
+		t.Fatal("sample rate 1.5 accepted")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gh-efforts/rbot/backfill"
 	"github.com/urfave/cli/v2"
@@ -18,9 +20,45 @@ var backfillCmd = &cli.Command{
 		&cli.StringSliceFlag{
 			Name: "provider",
 		},
+		&cli.StringSliceFlag{
+			Name: "client",
+		},
+		&cli.BoolFlag{
+			Name:  "verified-only",
+			Usage: "only backfill verified deals",
+		},
 		&cli.IntFlag{
 			Name: "start-epoch",
 		},
+		&cli.IntFlag{
+			Name:    "start-epoch-max",
+			Aliases: []string{"end-epoch"},
+			Usage:   "only backfill deals starting at or before this epoch",
+		},
+		&cli.IntFlag{
+			Name:  "active-at",
+			Usage: "only backfill deals active at this height",
+		},
+		&cli.Int64Flag{
+			Name:  "min-piece-size",
+			Usage: "minimum padded piece size in bytes",
+		},
+		&cli.Float64Flag{
+			Name:  "sample-rate",
+			Usage: "random sample rate per provider, within [0, 1], all the deals if not set",
+		},
+		&cli.StringSliceFlag{
+			Name:  "provider-sample-rate",
+			Usage: "override sample rate for a provider, eg: f01234=0.1, 0 excludes it",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only count the matched deals, do not insert",
+		},
+		&cli.BoolFlag{
+			Name:  "include-inactive",
+			Usage: "also insert slashed, expired and not activated deals",
+		},
 		&cli.StringFlag{
 			Name:  "connect",
 			Value: "127.0.0.1:5678",
@@ -28,8 +66,32 @@ var backfillCmd = &cli.Command{
 	},
 	Action: func(cctx *cli.Context) error {
 		f := backfill.Filter{
-			Providers:  cctx.StringSlice("provider"),
-			StartEpoch: cctx.Int("start-epoch"),
+			Providers:     cctx.StringSlice("provider"),
+			Clients:       cctx.StringSlice("client"),
+			VerifiedOnly:  cctx.Bool("verified-only"),
+			StartEpoch:    cctx.Int("start-epoch"),
+			StartEpochMax: cctx.Int("start-epoch-max"),
+			ActiveAt:      cctx.Int("active-at"),
+			MinPieceSize:  cctx.Int64("min-piece-size"),
+			SampleRates:   map[string]float64{},
+			DryRun:        cctx.Bool("dry-run"),
+
+			IncludeInactive: cctx.Bool("include-inactive"),
+		}
+		if cctx.IsSet("sample-rate") {
+			rate := cctx.Float64("sample-rate")
+			f.SampleRate = &rate
+		}
+		for _, s := range cctx.StringSlice("provider-sample-rate") {
+			p, rate, ok := strings.Cut(s, "=")
+			if !ok {
+				return fmt.Errorf("invalid provider-sample-rate: %s", s)
+			}
+			r, err := strconv.ParseFloat(rate, 64)
+			if err != nil {
+				return fmt.Errorf("invalid provider-sample-rate: %s: %w", s, err)
+			}
+			f.SampleRates[p] = r
 		}
 		body, err := json.Marshal(&f)
 		if err != nil {
@@ -43,13 +105,29 @@ var backfillCmd = &cli.Command{
 		}
 		defer resp.Body.Close()
 
+		r, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
 		if resp.StatusCode != http.StatusOK {
-			r, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return err
-			}
 			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
 		}
+
+		var res backfill.Result
+		err = json.Unmarshal(r, &res)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("dry-run: %t scanned: %d matched: %d inserted: %d\n", res.DryRun, res.Scanned, res.Matched, res.Inserted)
+		for p, n := range res.Providers {
+			fmt.Printf("%s: %d\n", p, n)
+		}
+		for reason, n := range res.Skipped {
+			fmt.Printf("skipped %s: %d\n", reason, n)
+		}
+		if res.Interrupted {
+			return fmt.Errorf("interrupted by a shutdown of rbot, run it again to go on")
+		}
 		return nil
 	},
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gh-efforts/rbot/retrieve"
+	"github.com/urfave/cli/v2"
+)
+
+var diagnoseFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "direct",
+		Usage: "fetch from the miner info addresses without the indexer: fallback, only",
+	},
+	&cli.StringFlag{
+		Name:  "path",
+		Usage: "UnixFS path inside the payload",
+	},
+	&cli.StringFlag{
+		Name:  "scope",
+		Usage: "dag scope: block, entity, all",
+	},
+	&cli.StringFlag{
+		Name:  "bytes",
+		Usage: "entity byte range of a large file, eg: 0:1048575",
+	},
+	&cli.BoolFlag{
+		Name:  "json",
+		Usage: "print the diagnosis as json",
+	},
+	&cli.StringFlag{
+		Name:  "connect",
+		Value: "127.0.0.1:5678",
+	},
+}
+
+var retrieveDealCmd = &cli.Command{
+	Name:      "deal",
+	Usage:     "retrieve a deal now and print the diagnosis",
+	ArgsUsage: "<dealID>",
+	Flags:     diagnoseFlags,
+	Action: func(cctx *cli.Context) error {
+		args, err := trailingFlags(cctx)
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("dealID required")
+		}
+		dealID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("dealID: %w", err)
+		}
+		return diagnose(cctx, retrieve.DiagnoseParam{DealID: dealID})
+	},
+}
+
+var retrieveCidCmd = &cli.Command{
+	Name:      "cid",
+	Usage:     "retrieve a payload from a provider now and print the diagnosis",
+	ArgsUsage: "<payloadCID> --provider <provider>, or <payloadCID> <provider>",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name: "provider",
+		},
+	}, diagnoseFlags...),
+	Action: func(cctx *cli.Context) error {
+		args, err := trailingFlags(cctx)
+		if err != nil {
+			return err
+		}
+		provider := cctx.String("provider")
+		if len(args) == 2 && provider == "" {
+			provider, args = args[1], args[:1]
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("payloadCID required")
+		}
+		if provider == "" {
+			return fmt.Errorf("provider required")
+		}
+		return diagnose(cctx, retrieve.DiagnoseParam{
+			PayloadCID: args[0],
+			Provider:   provider,
+		})
+	},
+}
+
+var retrieveFileCmd = &cli.Command{
+	Name:      "file",
+	Usage:     "retrieve a list of deals or payloads one by one and print the diagnoses",
+	ArgsUsage: "<list>, one <dealID> or <payloadCID> <provider> per line",
+	Flags:     diagnoseFlags,
+	Action: func(cctx *cli.Context) error {
+		args, err := trailingFlags(cctx)
+		if err != nil {
+			return err
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("list required")
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+
+			var dp retrieve.DiagnoseParam
+			switch len(fields) {
+			case 1:
+				dp.DealID, err = strconv.ParseInt(fields[0], 10, 64)
+				if err != nil {
+					return fmt.Errorf("dealID: %w", err)
+				}
+			case 2:
+				dp.PayloadCID = fields[0]
+				dp.Provider = fields[1]
+			default:
+				return fmt.Errorf("invalid line: %s", scanner.Text())
+			}
+
+			err = diagnose(cctx, dp)
+			if err != nil {
+				fmt.Printf("%s: %s\n\n", scanner.Text(), err)
+			}
+		}
+		return scanner.Err()
+	},
+}
+
+// trailingFlags sets the flags given after the arguments, eg: cid <payloadCID> --provider f01000,
+// which the parser leaves in the arguments, and returns the arguments left
+func trailingFlags(cctx *cli.Context) ([]string, error) {
+	all := cctx.Args().Slice()
+	args := []string{}
+	for i := 0; i < len(all); i++ {
+		a := all[i]
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			args = append(args, a)
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !ok {
+			if isBoolFlag(cctx, name) {
+				value = "true"
+			} else if i+1 < len(all) {
+				i++
+				value = all[i]
+			} else {
+				return nil, fmt.Errorf("flag needs an argument: %s", a)
+			}
+		}
+		err := cctx.Set(name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func isBoolFlag(cctx *cli.Context, name string) bool {
+	for _, f := range cctx.Command.Flags {
+		if _, ok := f.(*cli.BoolFlag); ok && slices.Contains(f.Names(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func diagnose(cctx *cli.Context, dp retrieve.DiagnoseParam) error {
+	dp.Direct = cctx.String("direct")
+	dp.Path = cctx.String("path")
+	dp.Scope = cctx.String("scope")
+	dp.Bytes = cctx.String("bytes")
+	body, err := json.Marshal(&dp)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/retrieve/diagnose", cctx.String("connect"))
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	r, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+	}
+
+	if cctx.Bool("json") {
+		fmt.Println(string(r))
+		return nil
+	}
+	var d retrieve.Diagnosis
+	err = json.Unmarshal(r, &d)
+	if err != nil {
+		return err
+	}
+	printDiagnosis(&d)
+	return nil
+}
+
+func printDiagnosis(d *retrieve.Diagnosis) {
+	fmt.Printf("deal: %d payload: %s provider: %s request: %s\n", d.DealID, d.PayloadCID, d.Provider, d.Request)
+	if d.MinerInfo != nil {
+		fmt.Printf("miner info: peer: %s addrs: %s\n", d.MinerInfo.PeerID, strings.Join(d.MinerInfo.Multiaddrs, " "))
+	}
+	for _, ix := range d.Indexers {
+		fmt.Printf("indexer: %s %s records: %d %s\n", ix.Endpoint, ix.Result, ix.Records, ix.Err)
+	}
+	for _, c := range d.Candidates {
+		fmt.Printf("candidate: %s addrs: %s protocols: %s %s\n", c.PeerID, strings.Join(c.Addrs, " "), strings.Join(c.Protocols, ","), c.Err)
+	}
+	if d.IndexerResult != "" {
+		fmt.Printf("indexer result: %s\n", d.IndexerResult)
+	}
+	if d.FetchResult != "" {
+		fmt.Printf("fetch result: %s\n", d.FetchResult)
+	}
+	if d.DirectResult != "" {
+		fmt.Printf("direct result: %s protocols: %s\n", d.DirectResult, d.DirectProtocols)
+	}
+	if d.Stats != nil {
+		fmt.Printf("stats: from: %s size: %d blocks: %d duration: %s first byte: %s speed: %d B/s\n", d.Stats.Provider, d.Stats.Size, d.Stats.Blocks, d.Stats.Duration, d.Stats.FirstByte, d.Stats.AverageSpeed)
+	}
+	if d.Err != "" {
+		fmt.Printf("error: %s\n", d.Err)
+	}
+	fmt.Printf("took: %s\n\n", d.Took)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gh-efforts/rbot/retrieve"
+	"github.com/urfave/cli/v2"
+)
+
+func TestRetrieveCidArgs(t *testing.T) {
+	var got retrieve.DiagnoseParam
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewDecoder(r.Body).Decode(&got)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(&retrieve.Diagnosis{})
+	}))
+	defer srv.Close()
+	connect := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, args := range [][]string{
+		{"cid", "bafkqaaa", "--provider", "f01000", "--connect", connect, "--json"},
+		{"cid", "--connect=" + connect, "bafkqaaa", "--provider=f01000"},
+		{"cid", "--provider", "f01000", "--connect", connect, "bafkqaaa"},
+		{"cid", "--connect", connect, "bafkqaaa", "f01000"},
+	} {
+		got = retrieve.DiagnoseParam{}
+		app := &cli.App{Commands: []*cli.Command{retrieveCmd}}
+		err := app.Run(append([]string{"rbot", "retrieve"}, args...))
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if got.PayloadCID != "bafkqaaa" || got.Provider != "f01000" {
+			t.Fatalf("%v: got payload %s provider %s", args, got.PayloadCID, got.Provider)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+var exportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "export deals or retrieval attempts",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "table",
+			Usage: "deals, attempts",
+			Value: "deals",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "csv, jsonl, parquet",
+			Value: "csv",
+		},
+		&cli.StringSliceFlag{
+			Name: "provider",
+		},
+		&cli.StringSliceFlag{
+			Name: "client",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "rows last updated (deals) or started (attempts) since a UTC time or a duration ago, eg: '2024-06-01 00:00:00', 168h",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "rows last updated or started before a UTC time or a duration ago",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "file to write, <table>.<format> if empty, - for stdout",
+		},
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		q := url.Values{}
+		q.Set("table", cctx.String("table"))
+		q.Set("format", cctx.String("format"))
+		for _, p := range cctx.StringSlice("provider") {
+			q.Add("provider", p)
+		}
+		for _, c := range cctx.StringSlice("client") {
+			q.Add("client", c)
+		}
+		for _, name := range []string{"from", "to"} {
+			if v := cctx.String(name); v != "" {
+				q.Set(name, v)
+			}
+		}
+
+		u := fmt.Sprintf("http://%s/api/v1/export?%s", cctx.String("connect"), q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		out := cctx.String("out")
+		if out == "" {
+			out = cctx.String("table") + "." + cctx.String("format")
+		}
+		var w io.Writer = os.Stdout
+		if out != "-" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		n, err := io.Copy(w, resp.Body)
+		if err != nil {
+			return err
+		}
+		if out != "-" {
+			fmt.Printf("%s: %d bytes\n", out, n)
+		}
+		return nil
+	},
+}
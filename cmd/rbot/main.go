@@ -1,20 +1,29 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
 	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/gh-efforts/rbot/alert"
 	"github.com/gh-efforts/rbot/backfill"
 	"github.com/gh-efforts/rbot/build"
+	"github.com/gh-efforts/rbot/export"
 	"github.com/gh-efforts/rbot/metrics"
+	"github.com/gh-efforts/rbot/node"
 	"github.com/gh-efforts/rbot/onchain"
 	"github.com/gh-efforts/rbot/repo"
+	"github.com/gh-efforts/rbot/report"
 	"github.com/gh-efforts/rbot/retrieve"
+	"github.com/gh-efforts/rbot/status"
 	"github.com/gh-efforts/rbot/web"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -32,6 +41,9 @@ func main() {
 		runCmd,
 		retrieveCmd,
 		backfillCmd,
+		exportCmd,
+		providerCmd,
+		statusCmd,
 		pprofCmd,
 	}
 
@@ -42,8 +54,9 @@ func main() {
 		Commands: local,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:  "repo",
-				Value: "~/.rbot",
+				Name:    "repo",
+				Value:   "~/.rbot",
+				EnvVars: []string{"RBOT_REPO"},
 			},
 		},
 	}
@@ -54,23 +67,33 @@ func main() {
 }
 
 var runCmd = &cli.Command{
-	Name: "run",
-	Flags: []cli.Flag{
+	Name:  "run",
+	Usage: "run the bot, the config is the config file overridden by the RBOT_* env overridden by the flags",
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
-			Name:  "listen",
-			Value: "0.0.0.0:5678",
+			Name:    "listen",
+			Value:   "0.0.0.0:5678",
+			EnvVars: []string{"RBOT_LISTEN"},
 		},
 		&cli.BoolFlag{
 			Name:  "debug",
 			Value: false,
 		},
-	},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Usage:   "on SIGTERM or SIGINT, wait as long for the runs in progress before interrupting them",
+			Value:   20 * time.Second,
+			EnvVars: []string{"RBOT_SHUTDOWN_TIMEOUT"},
+		},
+	}, configFlags()...),
 	Action: func(cctx *cli.Context) error {
 		setLog(cctx.Bool("debug"))
 
 		log.Info("starting retrieval bot ...")
 
-		ctx := cliutil.ReqContext(cctx)
+		// SIGHUP reloads the config instead of stopping
+		ctx, stop := signal.NotifyContext(cliutil.DaemonContext(cctx), syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
 
 		exporter, err := prometheus.NewExporter(prometheus.Options{
 			Namespace: "rbot",
@@ -94,49 +117,116 @@ var runCmd = &cli.Command{
 		if err != nil {
 			return err
 		}
-		r, err := repo.New(cctx.String("repo"))
+		r, err := repo.New(cctx.String("repo"), configOverrides(cctx))
 		if err != nil {
 			return err
 		}
 
-		fullnode, close, err := getFullNodeAPIV1(ctx, r.Conf.Lotus)
+		defer r.Close()
+
+		// the subsystems outlive ctx, they are stopped in order once it is done
+		bg := context.WithoutCancel(ctx)
+
+		fullnode, err := node.NewPool(bg, r.Conf().Lotus)
 		if err != nil {
 			return err
 		}
-		defer close()
+		defer fullnode.Close()
+		go fullnode.Run(ctx)
 
 		oc, err := onchain.New(ctx, r, fullnode)
 		if err != nil {
 			return err
 		}
-		go oc.SubscribeDealActivatedEvent(ctx)
+		eventsCtx, stopEvents := context.WithCancel(bg)
+		defer stopEvents()
+		eventsDone := make(chan struct{})
+		go func() {
+			defer close(eventsDone)
+			oc.SubscribeDealActivatedEvent(eventsCtx)
+		}()
 
-		rt, err := retrieve.New(ctx, r, fullnode)
+		rt, err := retrieve.New(bg, r, fullnode)
 		if err != nil {
 			return err
 		}
-		go rt.Run(ctx)
+		go reloadOnHangup(ctx, r)
+		al := alert.New(r)
+		rp := report.New(r)
+		rt.OnFinish(func(ctx context.Context, runID int64, kind string) {
+			// the manual and ad-hoc runs pick deals by hand, they say little of the providers
+			if kind != retrieve.RunCron {
+				return
+			}
+			al.Check(ctx, runID)
+			rp.RunReport(ctx, runID)
+		})
+		go rt.Run(bg)
+		rp.Run(ctx)
 
 		listen := cctx.String("listen")
 		log.Infow("rbot server", "listen", listen)
 
 		http.Handle("/metrics", exporter)
-		http.HandleFunc("/", web.New(r).Index)
-		http.HandleFunc("/backfill", backfill.New(r).Fill)
+		wb := web.New(r)
+		http.HandleFunc("/", wb.Index)
+		http.HandleFunc("/candidates", wb.Candidates)
+		bf := backfill.New(r, fullnode)
+		http.HandleFunc("/backfill", bf.Fill)
 		http.HandleFunc("/retrieve", rt.ManualRetrieve)
+		http.HandleFunc("/retrieve/runs", rt.Runs)
+		http.HandleFunc("/retrieve/cancel", rt.Cancel)
+		http.HandleFunc("/retrieve/car", rt.CarRetrieve)
+		http.HandleFunc("/retrieve/diagnose", rt.Diagnose)
+		http.HandleFunc("/reports", rp.Reports)
+		http.HandleFunc("/reports/", rp.Reports)
+		http.HandleFunc("/api/v1/export", export.New(r).Export)
+		http.HandleFunc("/admin/reload", r.ReloadHandler)
+		http.HandleFunc("/providers", r.ProvidersHandler)
+		http.HandleFunc("/health", fullnode.Health)
+		st := status.New(r, fullnode, oc, rt)
+		http.HandleFunc("/healthz", st.Healthz)
+		http.HandleFunc("/readyz", st.Readyz)
+		http.HandleFunc("/status", st.Status)
 
 		server := &http.Server{
 			Addr: listen,
 		}
-
+		serveErr := make(chan error, 1)
 		go func() {
-			<-ctx.Done()
-			time.Sleep(time.Millisecond * 100)
-			log.Info("closed rbot server")
-			server.Shutdown(ctx)
+			serveErr <- server.ListenAndServe()
 		}()
 
-		return server.ListenAndServe()
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+		}
+		// a second signal kills the process
+		stop()
+
+		// stop taking work, let the runs finish or interrupt them at the deadline, then
+		// stop the reports, the server, the events and the lotus connections before closing the DB
+		log.Infow("shutting down ...", "timeout", cctx.Duration("shutdown-timeout"))
+		st.ShuttingDown()
+		bf.Stop()
+		dctx, cancel := context.WithTimeout(bg, cctx.Duration("shutdown-timeout"))
+		defer cancel()
+		rt.Shutdown(dctx)
+		rp.Stop()
+
+		sctx, cancel := context.WithTimeout(bg, 5*time.Second)
+		defer cancel()
+		err = server.Shutdown(sctx)
+		if err != nil {
+			log.Warnw("shutdown server", "err", err)
+		}
+		log.Info("closed rbot server")
+
+		stopEvents()
+		<-eventsDone
+		log.Info("rbot stopped")
+		return nil
 	},
 }
 
@@ -150,6 +240,47 @@ var initCmd = &cli.Command{
 	},
 }
 
+// configFlags has a flag per key of the config, eg: --timeouts-first-byte
+func configFlags() []cli.Flag {
+	flags := []cli.Flag{}
+	for _, key := range repo.ConfigKeys() {
+		flags = append(flags, &cli.StringFlag{
+			Name:     repo.FlagName(key),
+			Usage:    fmt.Sprintf("override %s of the config, env %s", key, repo.EnvName(key)),
+			Category: "CONFIG",
+		})
+	}
+	return flags
+}
+
+func configOverrides(cctx *cli.Context) map[string]string {
+	overrides := map[string]string{}
+	for _, key := range repo.ConfigKeys() {
+		if cctx.IsSet(repo.FlagName(key)) {
+			overrides[key] = cctx.String(repo.FlagName(key))
+		}
+	}
+	return overrides
+}
+
+func reloadOnHangup(ctx context.Context, r *repo.Repo) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			err := r.Reload()
+			if err != nil {
+				log.Errorw("reload config", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func setLog(debug bool) {
 	level := "INFO"
 	if debug {
@@ -159,7 +290,12 @@ func setLog(debug bool) {
 	logging.SetLogLevel("main", level)
 	logging.SetLogLevel("repo", level)
 	logging.SetLogLevel("onchain", level)
+	logging.SetLogLevel("node", level)
 	logging.SetLogLevel("retrieve", level)
 	logging.SetLogLevel("web", level)
 	logging.SetLogLevel("backfill", level)
+	logging.SetLogLevel("alert", level)
+	logging.SetLogLevel("report", level)
+	logging.SetLogLevel("export", level)
+	logging.SetLogLevel("status", level)
 }
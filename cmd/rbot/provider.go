@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/urfave/cli/v2"
+)
+
+var providerCmd = &cli.Command{
+	Name:  "provider",
+	Usage: "manage the tracked providers",
+	Subcommands: []*cli.Command{
+		providerListCmd,
+		providerAddCmd,
+		providerUpdateCmd,
+		providerRemoveCmd,
+	},
+}
+
+var providerListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list the tracked providers",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		u := fmt.Sprintf("http://%s/providers", cctx.String("connect"))
+		resp, err := http.Get(u)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		r, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var providers []repo.Provider
+		err = json.Unmarshal(r, &providers)
+		if err != nil {
+			return err
+		}
+		for _, p := range providers {
+			state := "enabled"
+			if !p.Enabled {
+				state = "disabled"
+			}
+			fmt.Printf("%s\t%s\tteam: %s\tlabel: %s\tlimit: %d\n", p.Provider, state, p.Team, p.Label, p.Limit)
+		}
+		return nil
+	},
+}
+
+var providerAddCmd = &cli.Command{
+	Name:      "add",
+	Usage:     "track providers",
+	ArgsUsage: "<provider>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "team",
+		},
+		&cli.StringFlag{
+			Name: "label",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "deals retrieved per cron run, the limit of the schedule if 0",
+		},
+		&cli.BoolFlag{
+			Name:  "disabled",
+			Usage: "keep the provider but stop following and retrieving its deals",
+		},
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		return providerUpdates(cctx, http.MethodPost, "added", func(u *repo.ProviderUpdate) {
+			enabled := !cctx.Bool("disabled")
+			u.Enabled = &enabled
+		})
+	},
+}
+
+var providerUpdateCmd = &cli.Command{
+	Name:      "update",
+	Usage:     "update the given fields of tracked providers",
+	ArgsUsage: "<provider>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "team",
+		},
+		&cli.StringFlag{
+			Name: "label",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "deals retrieved per cron run, the limit of the schedule if 0",
+		},
+		&cli.BoolFlag{
+			Name:  "enabled",
+			Usage: "follow and retrieve the deals of the provider, --enabled=false stops it",
+		},
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		return providerUpdates(cctx, http.MethodPatch, "updated", func(u *repo.ProviderUpdate) {
+			if cctx.IsSet("enabled") {
+				enabled := cctx.Bool("enabled")
+				u.Enabled = &enabled
+			}
+		})
+	},
+}
+
+// providerUpdates sends the flags set to each provider of the args
+func providerUpdates(cctx *cli.Context, method, done string, setup func(*repo.ProviderUpdate)) error {
+	if cctx.NArg() == 0 {
+		return fmt.Errorf("no provider given")
+	}
+	u := fmt.Sprintf("http://%s/providers", cctx.String("connect"))
+	for _, provider := range cctx.Args().Slice() {
+		pu := &repo.ProviderUpdate{Provider: provider}
+		if cctx.IsSet("team") {
+			team := cctx.String("team")
+			pu.Team = &team
+		}
+		if cctx.IsSet("label") {
+			label := cctx.String("label")
+			pu.Label = &label
+		}
+		if cctx.IsSet("limit") {
+			limit := cctx.Int("limit")
+			pu.Limit = &limit
+		}
+		setup(pu)
+		body, err := json.Marshal(pu)
+		if err != nil {
+			return err
+		}
+		err = providerRequest(method, u, body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", provider, err)
+		}
+		fmt.Printf("%s %s\n", provider, done)
+	}
+	return nil
+}
+
+var providerRemoveCmd = &cli.Command{
+	Name:      "remove",
+	Usage:     "stop tracking providers, their deals are kept",
+	ArgsUsage: "<provider>...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() == 0 {
+			return fmt.Errorf("no provider given")
+		}
+		for _, provider := range cctx.Args().Slice() {
+			u := fmt.Sprintf("http://%s/providers?provider=%s", cctx.String("connect"), url.QueryEscape(provider))
+			err := providerRequest(http.MethodDelete, u, nil)
+			if err != nil {
+				return fmt.Errorf("%s: %w", provider, err)
+			}
+			fmt.Printf("%s removed\n", provider)
+		}
+		return nil
+	},
+}
+
+func providerRequest(method, u string, body []byte) error {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		r, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+	}
+	return nil
+}
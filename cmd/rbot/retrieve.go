@@ -5,7 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/gh-efforts/rbot/retrieve"
 	"github.com/urfave/cli/v2"
@@ -14,18 +19,87 @@ import (
 var retrieveCmd = &cli.Command{
 	Name:  "retrieve",
 	Usage: "manual retrieve",
+	Subcommands: []*cli.Command{
+		retrieveRunsCmd,
+		retrieveCancelCmd,
+		retrieveCarCmd,
+		retrieveDealCmd,
+		retrieveCidCmd,
+		retrieveFileCmd,
+	},
 	Flags: []cli.Flag{
 		&cli.StringSliceFlag{
 			Name: "provider",
 		},
 		&cli.IntFlag{
 			Name:  "limit",
-			Value: 100,
+			Usage: "deals sampled per provider, the limit of the config if 0, or deals selected in all by --deal, --payload, --deals-file and the filter, all if 0",
 		},
 		&cli.IntFlag{
 			Name:  "parallel",
 			Value: 10,
 		},
+		&cli.StringFlag{
+			Name:  "strategy",
+			Usage: "deal selection: random, never-tested, least-recent, recent-failed, newly-activated, by-client",
+			Value: "random",
+		},
+		&cli.BoolFlag{
+			Name:  "candidates",
+			Usage: "record every candidate the indexer returns for the payload",
+		},
+		&cli.BoolFlag{
+			Name:  "fetch-candidates",
+			Usage: "record and fetch from every candidate",
+		},
+		&cli.StringFlag{
+			Name:  "direct",
+			Usage: "fetch from the miner info addresses without the indexer: fallback, only",
+		},
+		&cli.StringFlag{
+			Name:  "path",
+			Usage: "UnixFS path inside the payload",
+		},
+		&cli.StringFlag{
+			Name:  "cid",
+			Usage: "block inside the payload DAG to fetch instead of the payload root",
+		},
+		&cli.StringFlag{
+			Name:  "scope",
+			Usage: "dag scope: block, entity, all",
+		},
+		&cli.StringFlag{
+			Name:  "bytes",
+			Usage: "entity byte range of a large file, eg: 0:1048575",
+		},
+		&cli.Int64SliceFlag{
+			Name:  "deal",
+			Usage: "retrieve these deals instead of sampling each provider",
+		},
+		&cli.StringSliceFlag{
+			Name:  "payload",
+			Usage: "retrieve the deals of these payloads, of the given providers if any",
+		},
+		&cli.StringFlag{
+			Name:  "deals-file",
+			Usage: "upload a list of deals to retrieve, one deal ID or payload CID per line",
+		},
+		&cli.StringSliceFlag{
+			Name:  "client",
+			Usage: "filter: retrieve the deals of these clients",
+		},
+		&cli.StringFlag{
+			Name:  "failed-since",
+			Usage: "filter: retrieve the deals failed since a UTC time or a duration ago, eg: '2024-06-01 00:00:00', 12h",
+		},
+		&cli.BoolFlag{
+			Name:  "never-tested",
+			Usage: "filter: retrieve the deals never tested",
+		},
+		&cli.BoolFlag{
+			Name:  "output",
+			Usage: "write the verified CAR of each fetch to the output dir of the config",
+		},
 		&cli.StringFlag{
 			Name:  "connect",
 			Value: "127.0.0.1:5678",
@@ -36,14 +110,43 @@ var retrieveCmd = &cli.Command{
 			Providers: cctx.StringSlice("provider"),
 			Limit:     cctx.Int("limit"),
 			Parallel:  cctx.Int("parallel"),
+			Strategy:  cctx.String("strategy"),
+
+			Candidates:      cctx.Bool("candidates"),
+			FetchCandidates: cctx.Bool("fetch-candidates"),
+			Direct:          cctx.String("direct"),
+			Path:            cctx.String("path"),
+			CID:             cctx.String("cid"),
+			Scope:           cctx.String("scope"),
+			Bytes:           cctx.String("bytes"),
+			Output:          cctx.Bool("output"),
+
+			DealIDs:     cctx.Int64Slice("deal"),
+			PayloadCIDs: cctx.StringSlice("payload"),
+		}
+		if cctx.IsSet("client") || cctx.IsSet("failed-since") || cctx.IsSet("never-tested") {
+			mp.Filter = &retrieve.DealFilter{
+				Clients:     cctx.StringSlice("client"),
+				FailedSince: cctx.String("failed-since"),
+				NeverTested: cctx.Bool("never-tested"),
+			}
 		}
-		body, err := json.Marshal(&mp)
+		param, err := json.Marshal(&mp)
 		if err != nil {
 			return err
 		}
 
+		contentType := "application/json"
+		body := bytes.NewBuffer(param)
+		if path := cctx.String("deals-file"); path != "" {
+			body, contentType, err = dealsForm(param, path)
+			if err != nil {
+				return err
+			}
+		}
+
 		url := fmt.Sprintf("http://%s/retrieve", cctx.String("connect"))
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+		resp, err := http.Post(url, contentType, body)
 		if err != nil {
 			return err
 		}
@@ -59,3 +162,184 @@ var retrieveCmd = &cli.Command{
 		return nil
 	},
 }
+
+// dealsForm builds the multipart form of a manual retrieval with the list of deals of the file
+func dealsForm(param []byte, path string) (*bytes.Buffer, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	err = mw.WriteField("param", string(param))
+	if err != nil {
+		return nil, "", err
+	}
+	fw, err := mw.CreateFormFile("deals", filepath.Base(path))
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = io.Copy(fw, f)
+	if err != nil {
+		return nil, "", err
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return body, mw.FormDataContentType(), nil
+}
+
+var retrieveRunsCmd = &cli.Command{
+	Name:  "runs",
+	Usage: "list the runs in progress",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/retrieve/runs", cctx.String("connect"))
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		r, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		var runs []retrieve.RunInfo
+		err = json.Unmarshal(r, &runs)
+		if err != nil {
+			return err
+		}
+		for _, rn := range runs {
+			fmt.Printf("%d\t%s\t%s\t%d tasks\n", rn.ID, rn.Kind, rn.Started.Format(time.DateTime), rn.Tasks)
+		}
+		return nil
+	},
+}
+
+var retrieveCancelCmd = &cli.Command{
+	Name:      "cancel",
+	Usage:     "cancel a run in progress, or all of them if no id is given",
+	ArgsUsage: "[runID]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		url := fmt.Sprintf("http://%s/retrieve/cancel?id=%s", cctx.String("connect"), cctx.Args().First())
+		resp, err := http.Post(url, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+		return nil
+	},
+}
+
+var retrieveCarCmd = &cli.Command{
+	Name:      "car",
+	Usage:     "retrieve a deal once and save the verified CAR",
+	ArgsUsage: "<dealID>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "CAR file to write, <dealID>.car if empty, - for stdout",
+		},
+		&cli.StringFlag{
+			Name:  "direct",
+			Usage: "fetch from the miner info addresses without the indexer: fallback, only",
+		},
+		&cli.StringFlag{
+			Name:  "path",
+			Usage: "UnixFS path inside the payload",
+		},
+		&cli.StringFlag{
+			Name:  "cid",
+			Usage: "block inside the payload DAG to fetch instead of the payload root",
+		},
+		&cli.StringFlag{
+			Name:  "scope",
+			Usage: "dag scope: block, entity, all",
+		},
+		&cli.StringFlag{
+			Name:  "bytes",
+			Usage: "entity byte range of a large file, eg: 0:1048575",
+		},
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		dealID := cctx.Args().First()
+		if dealID == "" {
+			return fmt.Errorf("dealID required")
+		}
+		q := url.Values{}
+		q.Set("dealID", dealID)
+		for _, name := range []string{"direct", "path", "cid", "scope", "bytes"} {
+			if v := cctx.String(name); v != "" {
+				q.Set(name, v)
+			}
+		}
+
+		u := fmt.Sprintf("http://%s/retrieve/car?%s", cctx.String("connect"), q.Encode())
+		resp, err := http.Get(u)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			r, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+
+		out := cctx.String("out")
+		if out == "" {
+			out = dealID + ".car"
+		}
+		var w io.Writer = os.Stdout
+		if out != "-" {
+			f, err := os.Create(out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		n, err := io.Copy(w, resp.Body)
+		if err != nil {
+			return err
+		}
+		if out != "-" {
+			fmt.Printf("%s: %d bytes\n", out, n)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gh-efforts/rbot/status"
+	"github.com/urfave/cli/v2"
+)
+
+var statusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "print the status of the bot, exits with an error if it is not ready",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the raw JSON status",
+		},
+		&cli.StringFlag{
+			Name:  "connect",
+			Value: "127.0.0.1:5678",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		u := fmt.Sprintf("http://%s/status", cctx.String("connect"))
+		resp, err := http.Get(u)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		r, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+			return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+		}
+		if cctx.Bool("json") {
+			os.Stdout.Write(r)
+		} else {
+			var rp status.Report
+			err = json.Unmarshal(r, &rp)
+			if err != nil {
+				return err
+			}
+			printStatus(&rp)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("not ready")
+		}
+		return nil
+	},
+}
+
+func printStatus(rp *status.Report) {
+	fmt.Printf("version: %s uptime: %s ready: %t\n", rp.Version, rp.Uptime, rp.Ready)
+	for _, p := range rp.Problems {
+		fmt.Printf("problem: %s\n", p)
+	}
+	fmt.Printf("db: ok: %t deals: %d %s\n", rp.DB.OK, rp.DB.Deals, rp.DB.Err)
+	fmt.Printf("lotus: ok: %t height: %d lag: %s (%d epochs) %s\n", rp.Lotus.OK, rp.Lotus.Height, rp.Lotus.Lag, rp.Lotus.LagEpochs, rp.Lotus.Err)
+	for _, n := range rp.Lotus.Nodes {
+		fmt.Printf("  node: %s healthy: %t subscribed: %t height: %d latency: %s %s\n", n.Addr, n.Healthy, n.Subscribed, n.Height, n.Latency, n.LastErr)
+	}
+	oc := rp.OnChain
+	fmt.Printf("onchain: subscribed: %t since: %s events: %d last event: %s at %d %s\n", oc.Subscribed, formatTime(oc.SubscribedAt), oc.Events, formatTime(oc.LastEvent), oc.LastEventHeight, oc.LastErr)
+	for _, rn := range rp.Running {
+		fmt.Printf("running: %d %s since %s, %d tasks\n", rn.ID, rn.Kind, rn.Started.Format(time.DateTime), rn.Tasks)
+	}
+	printRun("last run", rp.LastRun)
+	printRun("last cron run", rp.LastCronRun)
+}
+
+func printRun(name string, rn *status.RunStatus) {
+	if rn == nil {
+		fmt.Printf("%s: none\n", name)
+		return
+	}
+	fmt.Printf("%s: %d %s %s at %s, %d tasks, took %s %s\n", name, rn.ID, rn.Kind, rn.Result, rn.FinishedAt.Format(time.DateTime), rn.Tasks, rn.FinishedAt.Sub(rn.StartedAt), rn.ErrMsg)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.DateTime)
+}
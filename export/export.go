@@ -0,0 +1,272 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("export")
+
+// tables which can be exported
+const (
+	tableDeals    = "deals"
+	tableAttempts = "attempts"
+)
+
+type Export struct {
+	repo *repo.Repo
+}
+
+// Filter selects the rows of the providers and clients, all if empty, last updated
+// (deals) or started (attempts) within [From, To), unbounded if zero
+type Filter struct {
+	Providers []string
+	Clients   []string
+	From      time.Time
+	To        time.Time
+}
+
+func New(repo *repo.Repo) *Export {
+	return &Export{repo: repo}
+}
+
+// Export streams a table at /api/v1/export?table=deals|attempts&format=csv|jsonl|parquet,
+// filtered by provider and client (repeatable), from and to (UTC times or durations ago)
+func (e *Export) Export(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	table := q.Get("table")
+	if table == "" {
+		table = tableDeals
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	f := Filter{
+		Providers: q["provider"],
+		Clients:   q["client"],
+	}
+	var err error
+	if s := q.Get("from"); s != "" {
+		f.From, err = parseTime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		f.To, err = parseTime(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var query string
+	var args []any
+	switch table {
+	case tableDeals:
+		query, args = dealsQuery(f)
+	case tableAttempts:
+		query, args = attemptsQuery(f)
+	default:
+		http.Error(w, fmt.Sprintf("unknown table: %s", table), http.StatusBadRequest)
+		return
+	}
+
+	header := func() {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", table+"."+format))
+	}
+	n := 0
+	switch table {
+	case tableDeals:
+		n, err = write(req.Context(), e.repo.DB, w, header, format, query, args, scanDeal)
+	case tableAttempts:
+		n, err = write(req.Context(), e.repo.DB, w, header, format, query, args, scanAttempt)
+	}
+	var qe *queryError
+	if errors.As(err, &qe) && qe.first {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		// the response is already started, the client gets a truncated file
+		log.Errorw("export", "table", table, "format", format, "rows", n, "err", err)
+		return
+	}
+	log.Infow("export", "table", table, "format", format, "rows", n, "filter", f)
+}
+
+// pageSize is the rows read at once, the only connection of the DB is released between
+// pages so that a slow download does not block the writes
+const pageSize = 1000
+
+// queryError is a failure to read a page, first if nothing was written yet
+type queryError struct {
+	err   error
+	first bool
+}
+
+func (e *queryError) Error() string { return e.err.Error() }
+func (e *queryError) Unwrap() error { return e.err }
+
+// write encodes the rows of the query in the format page by page, and returns how many
+// were written, header is called before the first write. The query takes the key of the
+// last row of the previous page and the page size as its last two arguments, and scan
+// returns the key of each row
+func write[T row](ctx context.Context, db *sql.DB, w io.Writer, header func(), format, query string, args []any, scan func(*sql.Rows) (T, int64, error)) (int, error) {
+	var enc *encoder[T]
+	n := 0
+	after := int64(math.MinInt64)
+	for {
+		page, last, err := readPage(ctx, db, query, append(slices.Clip(args), after, pageSize), scan)
+		if err != nil {
+			return n, &queryError{err: err, first: enc == nil}
+		}
+		if enc == nil {
+			header()
+			enc, err = newEncoder[T](w, format)
+			if err != nil {
+				return n, err
+			}
+		}
+		for _, r := range page {
+			err = enc.encode(r)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(page) < pageSize {
+			return n, enc.finish()
+		}
+		after = last
+	}
+}
+
+// readPage reads a page of rows and closes them, last is the key of the last row
+func readPage[T row](ctx context.Context, db *sql.DB, query string, args []any, scan func(*sql.Rows) (T, int64, error)) ([]T, int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	page := []T{}
+	var last int64
+	for rows.Next() {
+		r, key, err := scan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		page = append(page, r)
+		last = key
+	}
+	return page, last, rows.Err()
+}
+
+// parseTime parses a UTC time or a duration ago
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time or duration: %s", s)
+}
+
+// where builds the conditions of the filter, the columns are qualified by their table alias
+func where(f Filter, provider, client, updated string) (string, []any) {
+	conds := []string{"1=1"}
+	args := []any{}
+	in := func(column string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		conds = append(conds, column+` IN (`+strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")+`)`)
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	in(provider, f.Providers)
+	in(client, f.Clients)
+	if !f.From.IsZero() {
+		conds = append(conds, updated+` >= ?`)
+		args = append(args, f.From.UTC().Format(time.DateTime))
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, updated+` < ?`)
+		args = append(args, f.To.UTC().Format(time.DateTime))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func dealsQuery(f Filter) (string, []any) {
+	cond, args := where(f, "d.provider", "d.client", "d.last_update")
+	return `SELECT d.deal_id, d.payload_cid, COALESCE(d.client, ''), COALESCE(d.provider, ''), COALESCE(d.start_epoch, 0), COALESCE(d.end_epoch, 0),
+		d.sector_start_epoch, d.slash_epoch, COALESCE(d.indexer_result, ''), COALESCE(d.indexer_peers, ''), COALESCE(d.fetch_result, ''), COALESCE(d.err_msg, ''),
+		COALESCE(d.direct_result, ''), COALESCE(d.direct_protocols, ''), COALESCE(d.direct_err_msg, ''), d.last_update
+		FROM Deals d WHERE ` + cond + ` AND d.deal_id > ? ORDER BY d.deal_id LIMIT ?`, args
+}
+
+func attemptsQuery(f Filter) (string, []any) {
+	cond, args := where(f, "a.provider", "d.client", "a.started_at")
+	// the attempts are paged in the order they were recorded
+	return `SELECT a.rowid, COALESCE(a.run_id, 0), a.deal_id, COALESCE(a.provider, ''), COALESCE(d.client, ''), COALESCE(a.attempt, 0), COALESCE(a.request, ''),
+		COALESCE(a.indexer_result, ''), COALESCE(a.fetch_result, ''), COALESCE(a.direct_result, ''), COALESCE(a.err_class, ''), COALESCE(a.err_msg, ''),
+		a.started_at, COALESCE(a.duration_ms, 0)
+		FROM Attempts a LEFT JOIN Deals d ON d.deal_id = a.deal_id WHERE ` + cond + ` AND a.rowid > ? ORDER BY a.rowid LIMIT ?`, args
+}
+
+func scanDeal(rows *sql.Rows) (Deal, int64, error) {
+	var d Deal
+	var sectorStart, slash sql.NullInt64
+	var lastUpdate sql.NullTime
+	err := rows.Scan(&d.DealID, &d.PayloadCID, &d.Client, &d.Provider, &d.StartEpoch, &d.EndEpoch,
+		&sectorStart, &slash, &d.IndexerResult, &d.IndexerPeers, &d.FetchResult, &d.ErrMsg,
+		&d.DirectResult, &d.DirectProtocols, &d.DirectErrMsg, &lastUpdate)
+	if sectorStart.Valid {
+		d.SectorStartEpoch = &sectorStart.Int64
+	}
+	if slash.Valid {
+		d.SlashEpoch = &slash.Int64
+	}
+	if lastUpdate.Valid {
+		d.LastUpdate = &lastUpdate.Time
+	}
+	return d, d.DealID, err
+}
+
+func scanAttempt(rows *sql.Rows) (Attempt, int64, error) {
+	var a Attempt
+	var key int64
+	var started sql.NullTime
+	err := rows.Scan(&key, &a.RunID, &a.DealID, &a.Provider, &a.Client, &a.Attempt, &a.Request,
+		&a.IndexerResult, &a.FetchResult, &a.DirectResult, &a.ErrClass, &a.ErrMsg,
+		&started, &a.DurationMs)
+	if started.Valid {
+		a.StartedAt = &started.Time
+	}
+	return a, key, err
+}
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// dbWriter writes to the DB on every write of the response, which blocks
+// while the export holds the connection
+type dbWriter struct {
+	*httptest.ResponseRecorder
+	repo *repo.Repo
+	err  error
+}
+
+func (w *dbWriter) Write(b []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := w.repo.DB.ExecContext(ctx, `UPDATE Deals SET err_msg='' WHERE deal_id=1`)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestExportPages(t *testing.T) {
+	dir := t.TempDir()
+	err := repo.Init(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.New(dir, map[string]string{"lotus": "eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	deals := 2*pageSize + 10
+	_, err = r.DB.Exec(`WITH RECURSIVE ids(id) AS (SELECT 1 UNION ALL SELECT id+1 FROM ids WHERE id < $1)
+		INSERT INTO Deals (deal_id, payload_cid, provider, last_update) SELECT id, 'cid', 'f01000', datetime('now') FROM ids`, deals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &dbWriter{ResponseRecorder: httptest.NewRecorder(), repo: r}
+	New(r).Export(w, httptest.NewRequest(http.MethodGet, "/api/v1/export?table=deals&format=csv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", w.Code, w.Body.String())
+	}
+	if w.err != nil {
+		t.Fatalf("DB write during the export: %v", w.err)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != deals+1 {
+		t.Fatalf("rows: got %d, want %d", len(lines)-1, deals)
+	}
+	if !strings.HasPrefix(lines[deals], "2010,") {
+		t.Fatalf("last row: got %s, want deal 2010", lines[deals])
+	}
+}
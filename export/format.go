@@ -0,0 +1,144 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// formats of the export
+const (
+	formatCSV     = "csv"
+	formatJSONL   = "jsonl"
+	formatParquet = "parquet"
+)
+
+var contentTypes = map[string]string{
+	formatCSV:     "text/csv",
+	formatJSONL:   "application/x-ndjson",
+	formatParquet: "application/vnd.apache.parquet",
+}
+
+// row is an exported row, its CSV columns follow the order of its header
+type row interface {
+	header() []string
+	record() []string
+}
+
+// Deal is a row of the deals export, its results are the repo.Result*, repo.Indexer* and repo.Direct* values
+type Deal struct {
+	DealID           int64      `json:"dealID" parquet:"deal_id"`
+	PayloadCID       string     `json:"payloadCID" parquet:"payload_cid"`
+	Client           string     `json:"client" parquet:"client"`
+	Provider         string     `json:"provider" parquet:"provider"`
+	StartEpoch       int64      `json:"startEpoch" parquet:"start_epoch"`
+	EndEpoch         int64      `json:"endEpoch" parquet:"end_epoch"`
+	SectorStartEpoch *int64     `json:"sectorStartEpoch" parquet:"sector_start_epoch,optional"`
+	SlashEpoch       *int64     `json:"slashEpoch" parquet:"slash_epoch,optional"`
+	IndexerResult    string     `json:"indexerResult" parquet:"indexer_result"`
+	IndexerPeers     string     `json:"indexerPeers" parquet:"indexer_peers"`
+	FetchResult      string     `json:"fetchResult" parquet:"fetch_result"`
+	ErrMsg           string     `json:"errMsg" parquet:"err_msg"`
+	DirectResult     string     `json:"directResult" parquet:"direct_result"`
+	DirectProtocols  string     `json:"directProtocols" parquet:"direct_protocols"`
+	DirectErrMsg     string     `json:"directErrMsg" parquet:"direct_err_msg"`
+	LastUpdate       *time.Time `json:"lastUpdate" parquet:"last_update,optional"`
+}
+
+func (d Deal) header() []string {
+	return []string{"deal_id", "payload_cid", "client", "provider", "start_epoch", "end_epoch", "sector_start_epoch", "slash_epoch",
+		"indexer_result", "indexer_peers", "fetch_result", "err_msg", "direct_result", "direct_protocols", "direct_err_msg", "last_update"}
+}
+
+func (d Deal) record() []string {
+	return []string{strconv.FormatInt(d.DealID, 10), d.PayloadCID, d.Client, d.Provider, strconv.FormatInt(d.StartEpoch, 10), strconv.FormatInt(d.EndEpoch, 10),
+		optInt(d.SectorStartEpoch), optInt(d.SlashEpoch), d.IndexerResult, d.IndexerPeers, d.FetchResult, d.ErrMsg,
+		d.DirectResult, d.DirectProtocols, d.DirectErrMsg, optTime(d.LastUpdate)}
+}
+
+// Attempt is a row of the attempts export, its results are those of Deal
+type Attempt struct {
+	RunID         int64      `json:"runID" parquet:"run_id"`
+	DealID        int64      `json:"dealID" parquet:"deal_id"`
+	Provider      string     `json:"provider" parquet:"provider"`
+	Client        string     `json:"client" parquet:"client"`
+	Attempt       int64      `json:"attempt" parquet:"attempt"`
+	Request       string     `json:"request" parquet:"request"`
+	IndexerResult string     `json:"indexerResult" parquet:"indexer_result"`
+	FetchResult   string     `json:"fetchResult" parquet:"fetch_result"`
+	DirectResult  string     `json:"directResult" parquet:"direct_result"`
+	ErrClass      string     `json:"errClass" parquet:"err_class"`
+	ErrMsg        string     `json:"errMsg" parquet:"err_msg"`
+	StartedAt     *time.Time `json:"startedAt" parquet:"started_at,optional"`
+	DurationMs    int64      `json:"durationMs" parquet:"duration_ms"`
+}
+
+func (a Attempt) header() []string {
+	return []string{"run_id", "deal_id", "provider", "client", "attempt", "request", "indexer_result", "fetch_result", "direct_result",
+		"err_class", "err_msg", "started_at", "duration_ms"}
+}
+
+func (a Attempt) record() []string {
+	return []string{strconv.FormatInt(a.RunID, 10), strconv.FormatInt(a.DealID, 10), a.Provider, a.Client, strconv.FormatInt(a.Attempt, 10), a.Request,
+		a.IndexerResult, a.FetchResult, a.DirectResult, a.ErrClass, a.ErrMsg, optTime(a.StartedAt), strconv.FormatInt(a.DurationMs, 10)}
+}
+
+func optInt(i *int64) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.FormatInt(*i, 10)
+}
+
+func optTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.DateTime)
+}
+
+// encoder writes the rows in a format, finish completes the file
+type encoder[T row] struct {
+	encode func(T) error
+	finish func() error
+}
+
+func newEncoder[T row](w io.Writer, format string) (*encoder[T], error) {
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		var zero T
+		err := cw.Write(zero.header())
+		if err != nil {
+			return nil, err
+		}
+		return &encoder[T]{
+			encode: func(r T) error { return cw.Write(r.record()) },
+			finish: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}, nil
+	case formatJSONL:
+		je := json.NewEncoder(w)
+		return &encoder[T]{
+			encode: func(r T) error { return je.Encode(r) },
+			finish: func() error { return nil },
+		}, nil
+	case formatParquet:
+		pw := parquet.NewGenericWriter[T](w)
+		return &encoder[T]{
+			encode: func(r T) error {
+				_, err := pw.Write([]T{r})
+				return err
+			},
+			finish: pw.Close,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
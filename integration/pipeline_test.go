@@ -0,0 +1,343 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	ltypes "github.com/filecoin-project/lassie/pkg/types"
+	"github.com/gh-efforts/rbot/internal/fake"
+	"github.com/gh-efforts/rbot/onchain"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/gh-efforts/rbot/retrieve"
+	"github.com/gh-efforts/rbot/web"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+)
+
+const (
+	height   = abi.ChainEpoch(10000)
+	client   = 1001
+	provider = 1000
+)
+
+// env is an rbot wired to a fake lotus, a fake indexer and a fake provider
+type env struct {
+	repo     *repo.Repo
+	lotus    *fake.Lotus
+	ipni     *fake.IPNI
+	provider *fake.Provider
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+
+	p, err := fake.NewProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	ipni := fake.NewIPNI()
+	t.Cleanup(ipni.Close)
+
+	lotus := fake.NewLotus(height)
+	lotus.SetMinerInfo(mustID(t, provider), p.MinerInfo())
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	err = repo.Init(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.New(dir, map[string]string{
+		// never dialed, the fake lotus is given to each subsystem
+		"lotus":          "eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http",
+		"providers":      mustID(t, provider).String(),
+		"indexers":       ipni.URL,
+		"retry.attempts": "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	return &env{repo: r, lotus: lotus, ipni: ipni, provider: p}
+}
+
+func mustID(t *testing.T, id uint64) address.Address {
+	t.Helper()
+	a, err := address.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// deal adds the deal of the payload to the chain and emits its activation
+func (e *env) deal(t *testing.T, id abi.DealID, provider uint64, payload cid.Cid) {
+	t.Helper()
+	d, err := fake.Deal(client, provider, payload, height-100, height+100000, height-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.lotus.AddDeal(id, d)
+	e.lotus.Emit(fake.DealActivated(height-10, id, client, provider))
+}
+
+// follow subscribes to the deal events until the end of the test
+func (e *env) follow(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	oc, err := onchain.New(ctx, e.repo, e.lotus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		oc.SubscribeDealActivatedEvent(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitFor(t, "events subscription", func() bool { return e.lotus.Subscriptions() > 0 })
+}
+
+// retrieve runs a manual retrieval of the deals
+func (e *env) retrieve(t *testing.T, dealIDs string, opts ...retrieve.Option) {
+	t.Helper()
+	rt, err := retrieve.New(context.Background(), e.repo, e.lotus, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rt.Shutdown(context.Background()) })
+
+	w := httptest.NewRecorder()
+	rt.ManualRetrieve(w, httptest.NewRequest(http.MethodPost, "/retrieve", strings.NewReader(`{"dealIDs":[`+dealIDs+`]}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("manual retrieve: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func (e *env) dealResults(t *testing.T, id abi.DealID) (string, string) {
+	t.Helper()
+	var indexerResult, fetchResult sql.NullString
+	err := e.repo.DB.QueryRow(`SELECT indexer_result, fetch_result FROM Deals WHERE deal_id=$1`, id).Scan(&indexerResult, &fetchResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return indexerResult.String, fetchResult.String
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func get(t *testing.T, h http.HandlerFunc, target string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, target, nil))
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, w.Code, body)
+	}
+	return string(body)
+}
+
+func TestDealPipeline(t *testing.T) {
+	e := newEnv(t)
+	e.follow(t)
+
+	payload := e.provider.Add([]byte("hello rbot"))
+	err := e.ipni.Announce(payload, e.provider.AddrInfo(), &metadata.IpfsGatewayHttp{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deal(t, 1, provider, payload)
+	// not a tracked provider, filtered out of the subscription
+	e.deal(t, 2, 2000, payload)
+
+	waitFor(t, "deal 1 in the DB", func() bool {
+		var n int
+		e.repo.DB.QueryRow(`SELECT COUNT(*) FROM Deals WHERE deal_id=1`).Scan(&n)
+		return n == 1
+	})
+	var stored string
+	err = e.repo.DB.QueryRow(`SELECT payload_cid FROM Deals WHERE deal_id=1`).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != payload.String() {
+		t.Fatalf("payload: got %s, want %s", stored, payload)
+	}
+	var n int
+	err = e.repo.DB.QueryRow(`SELECT COUNT(*) FROM Deals WHERE deal_id=2`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("deal of an untracked provider stored")
+	}
+
+	e.retrieve(t, "1")
+
+	indexerResult, fetchResult := e.dealResults(t, 1)
+	if indexerResult != repo.ResultOK || fetchResult != repo.ResultOK {
+		t.Fatalf("deal 1: indexer %q fetch %q, want OK OK", indexerResult, fetchResult)
+	}
+	if e.provider.Requests.Load() == 0 {
+		t.Fatal("the provider was never asked")
+	}
+	var runResult, attemptResult string
+	err = e.repo.DB.QueryRow(`SELECT result FROM Runs`).Scan(&runResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.repo.DB.QueryRow(`SELECT fetch_result FROM Attempts WHERE deal_id=1`).Scan(&attemptResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runResult != repo.ResultOK || attemptResult != repo.ResultOK {
+		t.Fatalf("run %q attempt %q, want OK OK", runResult, attemptResult)
+	}
+
+	wb := web.New(e.repo)
+	index := get(t, wb.Index, "/")
+	if !strings.Contains(index, payload.String()) {
+		t.Fatal("the index page misses the deal")
+	}
+	candidates := get(t, wb.Candidates, "/candidates?dealID=1")
+	if !strings.Contains(candidates, e.ipni.URL) {
+		t.Fatal("the candidates page misses the indexer result")
+	}
+}
+
+func TestRetrievalFailures(t *testing.T) {
+	cases := []struct {
+		name          string
+		setup         func(t *testing.T, e *env) cid.Cid
+		indexerResult string
+		fetchResult   string
+	}{{
+		name: "not indexed",
+		setup: func(t *testing.T, e *env) cid.Cid {
+			return e.provider.Add([]byte("not announced"))
+		},
+		indexerResult: repo.IndexerNotIndexed,
+	}, {
+		// both fake providers listen on 127.0.0.1
+		name: "indexed under another peer ID",
+		setup: func(t *testing.T, e *env) cid.Cid {
+			other, err := fake.NewProvider()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(other.Close)
+			payload := other.Add([]byte("elsewhere"))
+			err = e.ipni.Announce(payload, other.AddrInfo(), &metadata.IpfsGatewayHttp{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return payload
+		},
+		indexerResult: repo.IndexerPeerMismatch,
+	}, {
+		name: "data not served",
+		setup: func(t *testing.T, e *env) cid.Cid {
+			payload := fake.RawCid([]byte("lost"))
+			err := e.ipni.Announce(payload, e.provider.AddrInfo(), &metadata.IpfsGatewayHttp{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return payload
+		},
+		indexerResult: repo.ResultOK,
+		fetchResult:   repo.ResultErr,
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e := newEnv(t)
+			e.follow(t)
+			e.deal(t, 1, provider, c.setup(t, e))
+			waitFor(t, "deal 1 in the DB", func() bool {
+				var n int
+				err := e.repo.DB.QueryRow(`SELECT COUNT(*) FROM Deals WHERE deal_id=1`).Scan(&n)
+				return err == nil && n == 1
+			})
+
+			e.retrieve(t, "1")
+
+			indexerResult, fetchResult := e.dealResults(t, 1)
+			if indexerResult != c.indexerResult || fetchResult != c.fetchResult {
+				t.Fatalf("indexer %q fetch %q, want %q %q", indexerResult, fetchResult, c.indexerResult, c.fetchResult)
+			}
+		})
+	}
+}
+
+// bitswap fetches nothing, it records the protocols of the providers it is asked to fetch from
+type bitswap struct {
+	lk        sync.Mutex
+	protocols []string
+}
+
+func (b *bitswap) Fetch(ctx context.Context, req ltypes.RetrievalRequest, opts ...ltypes.FetchOption) (*ltypes.RetrievalStats, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	for _, p := range req.Providers {
+		for _, pr := range p.Protocols {
+			b.protocols = append(b.protocols, pr.ID().String())
+		}
+	}
+	return &ltypes.RetrievalStats{RootCid: req.Root, Size: 1}, nil
+}
+
+func TestBitswapCandidate(t *testing.T) {
+	e := newEnv(t)
+	e.follow(t)
+
+	payload := fake.RawCid([]byte("bitswap"))
+	err := e.ipni.Announce(payload, e.provider.AddrInfo(), &metadata.Bitswap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.deal(t, 1, provider, payload)
+	waitFor(t, "deal 1 in the DB", func() bool {
+		var n int
+		e.repo.DB.QueryRow(`SELECT COUNT(*) FROM Deals WHERE deal_id=1`).Scan(&n)
+		return n == 1
+	})
+
+	f := &bitswap{}
+	e.retrieve(t, "1", retrieve.WithFetcher(f))
+
+	indexerResult, fetchResult := e.dealResults(t, 1)
+	if indexerResult != repo.ResultOK || fetchResult != repo.ResultOK {
+		t.Fatalf("deal 1: indexer %q fetch %q, want OK OK", indexerResult, fetchResult)
+	}
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if len(f.protocols) != 1 || f.protocols[0] != "transport-bitswap" {
+		t.Fatalf("fetched protocols: got %v, want [transport-bitswap]", f.protocols)
+	}
+}
@@ -0,0 +1,77 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// IPNI is an indexer answering GET /multihash/<multihash> with the providers announced for it
+type IPNI struct {
+	*httptest.Server
+
+	lk        sync.Mutex
+	providers map[string][]model.ProviderResult
+}
+
+func NewIPNI() *IPNI {
+	ix := &IPNI{
+		providers: map[string][]model.ProviderResult{},
+	}
+	ix.Server = httptest.NewServer(http.HandlerFunc(ix.find))
+	return ix
+}
+
+// Announce indexes the payload at the provider with the metadata of its protocols
+func (ix *IPNI) Announce(c cid.Cid, provider peer.AddrInfo, protocols ...metadata.Protocol) error {
+	m := metadata.Default.New(protocols...)
+	md, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	ix.lk.Lock()
+	defer ix.lk.Unlock()
+	ix.providers[c.Hash().B58String()] = append(ix.providers[c.Hash().B58String()], model.ProviderResult{
+		ContextID: []byte(provider.ID),
+		Metadata:  md,
+		Provider:  &provider,
+	})
+	return nil
+}
+
+func (ix *IPNI) find(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/multihash/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	mh, err := multihash.FromB58String(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ix.lk.Lock()
+	providers := ix.providers[key]
+	ix.lk.Unlock()
+	if len(providers) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&model.FindResponse{
+		MultihashResults: []model.MultihashResult{{
+			Multihash:       mh,
+			ProviderResults: providers,
+		}},
+	})
+}
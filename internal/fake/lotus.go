@@ -0,0 +1,268 @@
+// Package fake has in-process stand-ins of lotus, an IPNI indexer and a storage provider,
+// for the tests to run offline
+package fake
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/must"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// Lotus serves the chain head, the market deals and the miner infos it is given,
+// and the events it emits to the subscriptions whose filter they match. A new
+// subscription gets the events emitted before it, as lotus replays them
+type Lotus struct {
+	lk     sync.Mutex
+	height abi.ChainEpoch
+	deals  map[abi.DealID]*api.MarketDeal
+	miners map[address.Address]api.MinerInfo
+	events []*types.ActorEvent
+	subs   []*subscription
+}
+
+type subscription struct {
+	ctx    context.Context
+	filter *types.ActorEventFilter
+	ch     chan *types.ActorEvent
+}
+
+func NewLotus(height abi.ChainEpoch) *Lotus {
+	return &Lotus{
+		height: height,
+		deals:  map[abi.DealID]*api.MarketDeal{},
+		miners: map[address.Address]api.MinerInfo{},
+	}
+}
+
+func (l *Lotus) SetHeight(height abi.ChainEpoch) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.height = height
+}
+
+func (l *Lotus) AddDeal(id abi.DealID, deal *api.MarketDeal) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.deals[id] = deal
+}
+
+func (l *Lotus) SetMinerInfo(miner address.Address, mi api.MinerInfo) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.miners[miner] = mi
+}
+
+// Emit sends the event to the subscriptions, it blocks until they receive it
+func (l *Lotus) Emit(e *types.ActorEvent) {
+	l.lk.Lock()
+	l.events = append(l.events, e)
+	subs := append([]*subscription{}, l.subs...)
+	l.lk.Unlock()
+
+	for _, s := range subs {
+		s.send(e)
+	}
+}
+
+// Subscriptions returns the number of subscriptions open
+func (l *Lotus) Subscriptions() int {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	n := 0
+	for _, s := range l.subs {
+		if s.ctx.Err() == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (l *Lotus) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	l.lk.Lock()
+	height := l.height
+	l.lk.Unlock()
+	return TipSet(height)
+}
+
+func (l *Lotus) StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	mi, ok := l.miners[miner]
+	if !ok {
+		return api.MinerInfo{}, fmt.Errorf("actor not found: %s", miner)
+	}
+	return mi, nil
+}
+
+func (l *Lotus) StateMarketStorageDeal(ctx context.Context, id abi.DealID, tsk types.TipSetKey) (*api.MarketDeal, error) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	deal, ok := l.deals[id]
+	if !ok {
+		return nil, fmt.Errorf("deal %d not found", id)
+	}
+	return deal, nil
+}
+
+func (l *Lotus) SubscribeActorEventsRaw(ctx context.Context, filter *types.ActorEventFilter) (<-chan *types.ActorEvent, error) {
+	s := &subscription{
+		ctx:    ctx,
+		filter: filter,
+		ch:     make(chan *types.ActorEvent),
+	}
+	l.lk.Lock()
+	past := append([]*types.ActorEvent{}, l.events...)
+	l.subs = append(l.subs, s)
+	l.lk.Unlock()
+
+	go func() {
+		for _, e := range past {
+			s.send(e)
+		}
+		<-ctx.Done()
+		l.lk.Lock()
+		defer l.lk.Unlock()
+		for i, sub := range l.subs {
+			if sub == s {
+				l.subs = append(l.subs[:i], l.subs[i+1:]...)
+				break
+			}
+		}
+		close(s.ch)
+	}()
+	return s.ch, nil
+}
+
+func (s *subscription) send(e *types.ActorEvent) {
+	if !s.match(e) {
+		return
+	}
+	select {
+	case s.ch <- e:
+	case <-s.ctx.Done():
+	}
+}
+
+// match applies the heights and the fields of the filter, an empty list of values
+// matches any value of the key
+func (s *subscription) match(e *types.ActorEvent) bool {
+	f := s.filter
+	if f.FromHeight != nil && e.Height < *f.FromHeight {
+		return false
+	}
+	if f.ToHeight != nil && e.Height > *f.ToHeight {
+		return false
+	}
+	for key, values := range f.Fields {
+		found := false
+		for _, entry := range e.Entries {
+			if entry.Key != key {
+				continue
+			}
+			if len(values) == 0 {
+				found = true
+			}
+			for _, v := range values {
+				if v.Codec == entry.Codec && bytes.Equal(v.Value, entry.Value) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DealActivated is the event of the storage market actor for the deal activated at height
+func DealActivated(height abi.ChainEpoch, id abi.DealID, client, provider uint64) *types.ActorEvent {
+	entry := func(key string, n ipld.Node) types.EventEntry {
+		return types.EventEntry{
+			Key:   key,
+			Codec: uint64(multicodec.Cbor),
+			Value: must.One(ipld.Encode(n, dagcbor.Encode)),
+		}
+	}
+	return &types.ActorEvent{
+		Entries: []types.EventEntry{
+			entry("$type", basicnode.NewString("deal-activated")),
+			entry("id", basicnode.NewInt(int64(id))),
+			entry("client", basicnode.NewInt(int64(client))),
+			entry("provider", basicnode.NewInt(int64(provider))),
+		},
+		Emitter: builtin.StorageMarketActorAddr,
+		Height:  height,
+		MsgCid:  RawCid([]byte(fmt.Sprintf("msg-%d", id))),
+	}
+}
+
+// TipSet is a tipset of a single block at the height, mined now
+func TipSet(height abi.ChainEpoch) (*types.TipSet, error) {
+	c := RawCid([]byte("block"))
+	return types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 builtin.SystemActorAddr,
+		Height:                height,
+		Ticket:                &types.Ticket{VRFProof: []byte{1}},
+		ParentStateRoot:       c,
+		Messages:              c,
+		ParentMessageReceipts: c,
+		ParentBaseFee:         types.NewInt(0),
+		ParentWeight:          types.NewInt(0),
+		Timestamp:             uint64(time.Now().Unix()),
+	}})
+}
+
+// RawCid is the CIDv1 of the data as a raw block
+func RawCid(data []byte) cid.Cid {
+	return cid.NewCidV1(cid.Raw, must.One(multihash.Sum(data, multihash.SHA2_256, -1)))
+}
+
+// Deal is an active market deal of the payload, from start to end, in a sector since
+// sectorStart, or -1 if not activated
+func Deal(client, provider uint64, payload cid.Cid, start, end, sectorStart abi.ChainEpoch) (*api.MarketDeal, error) {
+	label, err := market.NewLabelFromString(payload.String())
+	if err != nil {
+		return nil, err
+	}
+	c, err := address.NewIDAddress(client)
+	if err != nil {
+		return nil, err
+	}
+	p, err := address.NewIDAddress(provider)
+	if err != nil {
+		return nil, err
+	}
+	return &api.MarketDeal{
+		Proposal: market.DealProposal{
+			PieceCID:   RawCid([]byte("piece-" + payload.String())),
+			PieceSize:  abi.PaddedPieceSize(2048),
+			Client:     c,
+			Provider:   p,
+			Label:      label,
+			StartEpoch: start,
+			EndEpoch:   end,
+		},
+		State: api.MarketDealState{
+			SectorStartEpoch: sectorStart,
+			LastUpdatedEpoch: -1,
+			SlashEpoch:       -1,
+		},
+	}, nil
+}
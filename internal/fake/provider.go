@@ -0,0 +1,110 @@
+package fake
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Provider is a storage provider serving raw blocks over the trustless HTTP gateway
+// protocol: GET /ipfs/<cid> answers a CAR of the block. It does not serve Bitswap, the
+// tests of the Bitswap candidates inject a retrieve.Fetcher with retrieve.WithFetcher
+type Provider struct {
+	*httptest.Server
+	ID peer.ID
+
+	lk     sync.Mutex
+	blocks map[cid.Cid][]byte
+	// Requests counts the requests served
+	Requests atomic.Int64
+}
+
+func NewProvider() (*Provider, error) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	p := &Provider{
+		ID:     id,
+		blocks: map[cid.Cid][]byte{},
+	}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p, nil
+}
+
+// Add stores the data as a raw block and returns its CID
+func (p *Provider) Add(data []byte) cid.Cid {
+	c := RawCid(data)
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.blocks[c] = data
+	return c
+}
+
+// AddrInfo is the peer of the provider with its HTTP address
+func (p *Provider) AddrInfo() peer.AddrInfo {
+	host, port, _ := net.SplitHostPort(p.Listener.Addr().String())
+	addr := ma.StringCast(fmt.Sprintf("/ip4/%s/tcp/%s/http", host, port))
+	return peer.AddrInfo{ID: p.ID, Addrs: []ma.Multiaddr{addr}}
+}
+
+func (p *Provider) serve(w http.ResponseWriter, r *http.Request) {
+	p.Requests.Add(1)
+	s, ok := strings.CutPrefix(r.URL.Path, "/ipfs/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	c, err := cid.Parse(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.lk.Lock()
+	data, ok := p.blocks[c]
+	p.lk.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1; order=dfs; dups=y")
+	car, err := storage.NewWritable(w, []cid.Cid{c}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = car.Put(r.Context(), c.KeyString(), data)
+	if err != nil {
+		return
+	}
+	car.Finalize()
+}
+
+// MinerInfo is the miner info on chain of the provider
+func (p *Provider) MinerInfo() api.MinerInfo {
+	id := p.ID
+	mi := api.MinerInfo{PeerId: &id}
+	for _, a := range p.AddrInfo().Addrs {
+		mi.Multiaddrs = append(mi.Multiaddrs, a.Bytes())
+	}
+	return mi
+}
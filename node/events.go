@@ -0,0 +1,191 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/miner"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// dedupWindow is how far below the highest event seen the events are remembered,
+// a subscription replays the events since the last finalized tipset
+const dedupWindow = miner.ChainFinality + 100
+
+// dedup drops the events already seen from another node or an earlier subscription
+type dedup struct {
+	seen map[[sha256.Size]byte]abi.ChainEpoch
+	max  abi.ChainEpoch
+}
+
+func newDedup() *dedup {
+	return &dedup{seen: map[[sha256.Size]byte]abi.ChainEpoch{}}
+}
+
+// first tells if the event is seen for the first time
+func (d *dedup) first(e *types.ActorEvent) bool {
+	h := sha256.New()
+	h.Write(e.MsgCid.Bytes())
+	h.Write(e.TipSetKey.Bytes())
+	h.Write(e.Emitter.Bytes())
+	if e.Reverted {
+		h.Write([]byte{1})
+	}
+	for _, entry := range e.Entries {
+		h.Write([]byte(entry.Key))
+		h.Write(entry.Value)
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = e.Height
+	if e.Height > d.max {
+		d.max = e.Height
+		for k, height := range d.seen {
+			if height < d.max-dedupWindow {
+				delete(d.seen, k)
+			}
+		}
+	}
+	return true
+}
+
+// subscription is an events subscription open on a node
+type subscription struct {
+	n      *node
+	ch     <-chan *types.ActorEvent
+	down   <-chan struct{}
+	cancel context.CancelFunc
+}
+
+// SubscribeActorEventsRaw subscribes on the healthiest node, and resubscribes on the
+// next healthiest when the subscription fails or the node turns unhealthy, from the
+// height of the last event or of the head at the first subscription, the events
+// replayed are dropped. The channel is closed when ctx is done
+func (p *Pool) SubscribeActorEventsRaw(ctx context.Context, filter *types.ActorEventFilter) (<-chan *types.ActorEvent, error) {
+	n, fn, err := p.best(nil)
+	if err != nil {
+		return nil, err
+	}
+	// the events between the first subscription and a failover are replayed on the next node
+	from := filter.FromHeight
+	if from == nil {
+		head, err := fn.ChainHead(ctx)
+		if err != nil {
+			if nodeFailed(ctx, err) {
+				p.fail(n, err)
+			}
+			return nil, err
+		}
+		height := head.Height()
+		from = &height
+	}
+	sub, err := p.subscribeNode(ctx, n, fn, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *types.ActorEvent)
+	go func() {
+		defer close(out)
+		d := newDedup()
+		for {
+			err := p.forward(ctx, sub, d, out, &from)
+			sub.cancel()
+			p.unsubscribed(sub.n)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == errSubscriptionClosed {
+				p.fail(sub.n, err)
+			}
+			log.Warnw("events subscription lost", "addr", sub.n.addr, "err", err)
+
+			sub = nil
+			for sub == nil {
+				n, fn, err = p.best(nil)
+				if err == nil {
+					sub, err = p.subscribeNode(ctx, n, fn, filter, from)
+				}
+				if err != nil {
+					log.Errorw("resubscribe events", "err", err)
+					select {
+					case <-time.After(10 * time.Second):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			log.Infow("events resubscribed", "addr", n.addr, "from", *from)
+		}
+	}()
+	return out, nil
+}
+
+var (
+	errSubscriptionClosed = errors.New("events subscription closed")
+	errNodeUnhealthy      = errors.New("node unhealthy")
+)
+
+// forward sends the new events of the subscription to out until it is closed or its
+// node turns unhealthy, from is the height of the last event, nil is returned when ctx is done
+func (p *Pool) forward(ctx context.Context, sub *subscription, d *dedup, out chan<- *types.ActorEvent, from **abi.ChainEpoch) error {
+	for {
+		select {
+		case e, ok := <-sub.ch:
+			if !ok {
+				return errSubscriptionClosed
+			}
+			if !d.first(e) {
+				continue
+			}
+			height := e.Height
+			*from = &height
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-sub.down:
+			return errNodeUnhealthy
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *Pool) subscribeNode(ctx context.Context, n *node, fn v1api.FullNode, filter *types.ActorEventFilter, from *abi.ChainEpoch) (*subscription, error) {
+	f := *filter
+	if from != nil {
+		f.FromHeight = from
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	ch, err := fn.SubscribeActorEventsRaw(sctx, &f)
+	if err != nil {
+		cancel()
+		if nodeFailed(ctx, err) {
+			p.fail(n, err)
+		}
+		return nil, err
+	}
+
+	p.lk.Lock()
+	n.subscriptions++
+	down := n.down
+	p.lk.Unlock()
+	log.Infow("events subscribed", "addr", n.addr)
+	return &subscription{n: n, ch: ch, down: down, cancel: cancel}, nil
+}
+
+func (p *Pool) unsubscribed(n *node) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	n.subscriptions--
+}
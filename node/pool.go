@@ -0,0 +1,336 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("node")
+
+const (
+	checkInterval = 30 * time.Second
+	checkTimeout  = 10 * time.Second
+)
+
+// node is a lotus full node of the pool, its fields are guarded by the lock of the pool
+type node struct {
+	addr   string
+	header http.Header
+	api    v1api.FullNode
+	closer jsonrpc.ClientCloser
+
+	healthy bool
+	// down is closed when the node turns unhealthy, and replaced when it is healthy again
+	down      chan struct{}
+	height    abi.ChainEpoch
+	latency   time.Duration
+	lastErr   string
+	lastCheck time.Time
+	// events subscriptions open on the node
+	subscriptions int
+}
+
+// Pool spreads the lotus calls over several nodes: state calls go to the healthiest
+// node and fail over to the next ones on connection errors, events are subscribed on
+// one node at a time and resubscribed on another when it fails
+type Pool struct {
+	// the connections live as long as ctx
+	ctx   context.Context
+	lk    sync.Mutex
+	nodes []*node
+	// set by Close, the nodes are no longer connected
+	closed bool
+}
+
+// NewPool connects to the lotus API infos, at least one node must be healthy
+func NewPool(ctx context.Context, infos []string) (*Pool, error) {
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("could not get API info: lotus is empty")
+	}
+	p := &Pool{ctx: ctx}
+	for _, i := range infos {
+		ainfo := cliutil.ParseApiInfo(i)
+		addr, err := ainfo.DialArgs("v1")
+		if err != nil {
+			return nil, fmt.Errorf("could not get DialArgs: %w", err)
+		}
+		p.nodes = append(p.nodes, &node{addr: addr, header: ainfo.AuthHeader()})
+	}
+
+	p.check(ctx)
+	if _, _, err := p.best(nil); err != nil {
+		for _, n := range p.Status() {
+			err = fmt.Errorf("%w; %s: %s", err, n.Addr, n.LastErr)
+		}
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Run checks the health of the nodes until ctx is done
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Pool) Close() {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.closed = true
+	for _, n := range p.nodes {
+		if n.closer != nil {
+			n.closer()
+			n.closer = nil
+		}
+		n.api = nil
+		n.setHealthy(false)
+	}
+}
+
+// setHealthy updates the health of the node, with the lock of the pool held
+func (n *node) setHealthy(healthy bool) {
+	if healthy == n.healthy {
+		return
+	}
+	n.healthy = healthy
+	if healthy {
+		n.down = make(chan struct{})
+	} else if n.down != nil {
+		close(n.down)
+	}
+}
+
+// check connects the nodes not connected yet and gets the chain head of each
+func (p *Pool) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, n := range p.nodes {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			height, err := p.checkNode(cctx, n)
+			latency := time.Since(start)
+
+			p.lk.Lock()
+			defer p.lk.Unlock()
+			if p.closed {
+				return
+			}
+			n.lastCheck = time.Now()
+			if err != nil {
+				if n.healthy {
+					log.Warnw("lotus node unhealthy", "addr", n.addr, "err", err)
+				}
+				n.setHealthy(false)
+				n.lastErr = err.Error()
+				return
+			}
+			if !n.healthy {
+				log.Infow("lotus node healthy", "addr", n.addr, "height", height)
+			}
+			n.setHealthy(true)
+			n.height = height
+			n.latency = latency
+			n.lastErr = ""
+		}(n)
+	}
+	wg.Wait()
+}
+
+var errPoolClosed = errors.New("lotus pool closed")
+
+func (p *Pool) checkNode(ctx context.Context, n *node) (abi.ChainEpoch, error) {
+	p.lk.Lock()
+	fn, closed := n.api, p.closed
+	p.lk.Unlock()
+	if closed {
+		return 0, errPoolClosed
+	}
+
+	if fn == nil {
+		v1, closer, err := client.NewFullNodeRPCV1(p.ctx, n.addr, n.header)
+		if err != nil {
+			return 0, err
+		}
+		v, err := v1.Version(ctx)
+		if err != nil {
+			closer()
+			return 0, err
+		}
+		if !v.APIVersion.EqMajorMinor(api.FullAPIVersion1) {
+			closer()
+			return 0, fmt.Errorf("remote API version didn't match (expected %s, remote %s)", api.FullAPIVersion1, v.APIVersion)
+		}
+		// the pool may have been closed while connecting
+		p.lk.Lock()
+		if p.closed {
+			p.lk.Unlock()
+			closer()
+			return 0, errPoolClosed
+		}
+		n.api, n.closer = v1, closer
+		p.lk.Unlock()
+		log.Infow("connected to lotus", "addr", n.addr)
+		fn = v1
+	}
+
+	head, err := fn.ChainHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return head.Height(), nil
+}
+
+// best returns the healthy node with the highest head, then the lowest latency,
+// skipping the nodes in exclude, and its API as of now since Close clears it
+func (p *Pool) best(exclude []*node) (*node, v1api.FullNode, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	var best *node
+	for _, n := range p.nodes {
+		if !n.healthy || n.api == nil || slices.Contains(exclude, n) {
+			continue
+		}
+		if best == nil || n.height > best.height || (n.height == best.height && n.latency < best.latency) {
+			best = n
+		}
+	}
+	if best == nil {
+		return nil, nil, errors.New("no healthy lotus node")
+	}
+	return best, best.api, nil
+}
+
+// fail marks the node unhealthy until the next check passes
+func (p *Pool) fail(n *node, err error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if n.healthy {
+		log.Warnw("lotus node failed", "addr", n.addr, "err", err)
+	}
+	n.setHealthy(false)
+	n.lastErr = err.Error()
+}
+
+// nodeFailed tells the errors of the node from the errors of the call
+func nodeFailed(ctx context.Context, err error) bool {
+	var cerr *jsonrpc.RPCConnectionError
+	if errors.As(err, &cerr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil
+}
+
+// call runs f on the healthiest node, then on the next ones while the node fails
+func call[T any](ctx context.Context, p *Pool, f func(v1api.FullNode) (T, error)) (T, error) {
+	var tried []*node
+	for {
+		n, fn, err := p.best(tried)
+		if err != nil {
+			var zero T
+			if len(tried) != 0 {
+				err = fmt.Errorf("%w, tried %d", err, len(tried))
+			}
+			return zero, err
+		}
+		ret, err := f(fn)
+		if err != nil && nodeFailed(ctx, err) {
+			p.fail(n, err)
+			tried = append(tried, n)
+			continue
+		}
+		return ret, err
+	}
+}
+
+func (p *Pool) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return call(ctx, p, func(fn v1api.FullNode) (*types.TipSet, error) {
+		return fn.ChainHead(ctx)
+	})
+}
+
+func (p *Pool) StateMinerInfo(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	return call(ctx, p, func(fn v1api.FullNode) (api.MinerInfo, error) {
+		return fn.StateMinerInfo(ctx, addr, tsk)
+	})
+}
+
+func (p *Pool) StateMarketStorageDeal(ctx context.Context, dealID abi.DealID, tsk types.TipSetKey) (*api.MarketDeal, error) {
+	return call(ctx, p, func(fn v1api.FullNode) (*api.MarketDeal, error) {
+		return fn.StateMarketStorageDeal(ctx, dealID, tsk)
+	})
+}
+
+// NodeStatus is the health of a node as of its last check
+type NodeStatus struct {
+	Addr       string         `json:"addr"`
+	Healthy    bool           `json:"healthy"`
+	Subscribed bool           `json:"subscribed"`
+	Height     abi.ChainEpoch `json:"height"`
+	Latency    string         `json:"latency"`
+	LastErr    string         `json:"lastErr,omitempty"`
+	LastCheck  time.Time      `json:"lastCheck"`
+}
+
+func (p *Pool) Status() []NodeStatus {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	status := []NodeStatus{}
+	for _, n := range p.nodes {
+		status = append(status, NodeStatus{
+			Addr:       n.addr,
+			Healthy:    n.healthy,
+			Subscribed: n.subscriptions > 0,
+			Height:     n.height,
+			Latency:    n.latency.String(),
+			LastErr:    n.lastErr,
+			LastCheck:  n.lastCheck,
+		})
+	}
+	return status
+}
+
+// Healthy tells if at least one node is healthy
+func (p *Pool) Healthy() bool {
+	_, _, err := p.best(nil)
+	return err == nil
+}
+
+// Health serves the status of the nodes at /health, 503 if none is healthy
+func (p *Pool) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !p.Healthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(p.Status())
+}
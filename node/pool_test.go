@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gh-efforts/rbot/internal/fake"
+)
+
+// lotus is a fake full node whose chain head fails while it is down
+type lotus struct {
+	v1api.FullNode
+	chain *fake.Lotus
+	down  atomic.Bool
+}
+
+func (l *lotus) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	if l.down.Load() {
+		return nil, errors.New("down")
+	}
+	return l.chain.ChainHead(ctx)
+}
+
+func (l *lotus) SubscribeActorEventsRaw(ctx context.Context, filter *types.ActorEventFilter) (<-chan *types.ActorEvent, error) {
+	return l.chain.SubscribeActorEventsRaw(ctx, filter)
+}
+
+func testPool(t *testing.T, nodes ...*lotus) *Pool {
+	t.Helper()
+	p := &Pool{ctx: context.Background()}
+	for i, l := range nodes {
+		p.nodes = append(p.nodes, &node{addr: string(rune('a' + i)), api: l})
+	}
+	p.check(context.Background())
+	return p
+}
+
+func receive(t *testing.T, ch <-chan *types.ActorEvent) *types.ActorEvent {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return nil
+	}
+}
+
+func waitSubscribed(t *testing.T, l *lotus) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for l.chain.Subscriptions() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("not subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscriptionMovesOffUnhealthyNode(t *testing.T) {
+	a := &lotus{chain: fake.NewLotus(100)}
+	b := &lotus{chain: fake.NewLotus(99)}
+	p := testPool(t, a, b)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := p.SubscribeActorEventsRaw(ctx, &types.ActorEventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, a)
+
+	// a fails its check before any event, b replays from the head at the subscription
+	a.down.Store(true)
+	p.check(ctx)
+	waitSubscribed(t, b)
+
+	b.chain.Emit(fake.DealActivated(90, 1, 1001, 1000))
+	b.chain.Emit(fake.DealActivated(100, 2, 1001, 1000))
+	e := receive(t, ch)
+	if e.Height != abi.ChainEpoch(100) {
+		t.Fatalf("first event at %d, want 100, the events before the subscription are not replayed", e.Height)
+	}
+	if a.chain.Subscriptions() != 0 {
+		t.Fatal("still subscribed on the unhealthy node")
+	}
+
+	// a is back, b fails: the event seen on b is dropped when a replays it
+	a.down.Store(false)
+	p.check(ctx)
+	a.chain.Emit(fake.DealActivated(100, 2, 1001, 1000))
+	a.chain.Emit(fake.DealActivated(101, 3, 1001, 1000))
+	b.down.Store(true)
+	p.check(ctx)
+	e = receive(t, ch)
+	if e.Height != abi.ChainEpoch(101) {
+		t.Fatalf("event at %d after failover, want 101", e.Height)
+	}
+}
+
+func TestCheckAfterClose(t *testing.T) {
+	a := &lotus{chain: fake.NewLotus(100)}
+	p := testPool(t, a)
+	p.Close()
+
+	p.check(context.Background())
+	n := p.nodes[0]
+	if n.healthy || n.api != nil {
+		t.Fatalf("node after close: healthy %v connected %v", n.healthy, n.api != nil)
+	}
+}
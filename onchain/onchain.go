@@ -3,7 +3,9 @@ package onchain
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/filecoin-project/go-address"
@@ -28,16 +30,70 @@ type lotusApi interface {
 	StateMarketStorageDeal(context.Context, abi.DealID, types.TipSetKey) (*api.MarketDeal, error)
 }
 type OnChain struct {
-	repo      *repo.Repo
-	lotusApi  lotusApi
-	providers []uint64
+	repo     *repo.Repo
+	lotusApi lotusApi
+	// signaled when the tracked providers change
+	resubscribe chan struct{}
+
+	lk     sync.Mutex
+	status Status
+}
+
+// Status is the state of the events subscription
+type Status struct {
+	Subscribed      bool           `json:"subscribed"`
+	SubscribedAt    time.Time      `json:"subscribedAt"`
+	LastEvent       time.Time      `json:"lastEvent"`
+	LastEventHeight abi.ChainEpoch `json:"lastEventHeight"`
+	// Events counts the events received since the start
+	Events  int64  `json:"events"`
+	LastErr string `json:"lastErr,omitempty"`
+}
+
+func (oc *OnChain) Status() Status {
+	oc.lk.Lock()
+	defer oc.lk.Unlock()
+	return oc.status
+}
+
+func (oc *OnChain) setStatus(f func(s *Status)) {
+	oc.lk.Lock()
+	defer oc.lk.Unlock()
+	f(&oc.status)
 }
 
 func New(ctx context.Context, repo *repo.Repo, lotusApi lotusApi) (*OnChain, error) {
-	log.Infow("OnChain", "providers", repo.Conf.Providers)
+	providers, err := repo.TrackedProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Infow("OnChain", "providers", providers)
+
+	_, err = providerIDs(providers)
+	if err != nil {
+		return nil, err
+	}
 
-	providers := []uint64{}
-	for _, c := range repo.Conf.Providers {
+	oc := &OnChain{
+		repo:        repo,
+		lotusApi:    lotusApi,
+		resubscribe: make(chan struct{}, 1),
+	}
+	repo.OnProvidersChange(oc.providersChanged)
+
+	return oc, nil
+}
+
+func (oc *OnChain) providersChanged() {
+	select {
+	case oc.resubscribe <- struct{}{}:
+	default:
+	}
+}
+
+func providerIDs(providers []string) ([]uint64, error) {
+	ids := []uint64{}
+	for _, c := range providers {
 		a, err := address.NewFromString(c)
 		if err != nil {
 			return nil, err
@@ -46,20 +102,35 @@ func New(ctx context.Context, repo *repo.Repo, lotusApi lotusApi) (*OnChain, err
 		if err != nil {
 			return nil, err
 		}
-		providers = append(providers, id)
+		ids = append(ids, id)
 	}
+	return ids, nil
+}
 
-	oc := &OnChain{
-		repo:      repo,
-		lotusApi:  lotusApi,
-		providers: providers,
-	}
+// errNoProviders is returned by filter when providers were added but none is enabled
+var errNoProviders = errors.New("no provider enabled")
 
-	return oc, nil
-}
+// filter subscribes only to deal-activated events and the tracked providers from storage marker actor,
+// or every provider if none was ever added
+func (oc *OnChain) filter(ctx context.Context) (*types.ActorEventFilter, []string, error) {
+	tracked, err := oc.repo.TrackedProviders(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := providerIDs(tracked)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		all, err := oc.repo.TrackAll(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !all {
+			return nil, nil, errNoProviders
+		}
+	}
 
-func (oc *OnChain) SubscribeDealActivatedEvent(ctx context.Context) {
-	// subscribe event only to deal-activated events and specified providers from storage marker actor
 	dealActivatedCbor := must.One(ipld.Encode(basicnode.NewString("deal-activated"), dagcbor.Encode))
 	filter := &types.ActorEventFilter{
 		Addresses: []address.Address{builtin.StorageMarketActorAddr},
@@ -70,9 +141,9 @@ func (oc *OnChain) SubscribeDealActivatedEvent(ctx context.Context) {
 		},
 	}
 
-	if len(oc.providers) != 0 {
-		providers := make([]types.ActorEventBlock, len(oc.providers))
-		for _, provider := range oc.providers {
+	if len(ids) != 0 {
+		providers := make([]types.ActorEventBlock, 0, len(ids))
+		for _, provider := range ids {
 			aeb := types.ActorEventBlock{
 				Codec: uint64(multicodec.Cbor),
 				Value: must.One(ipld.Encode(basicnode.NewInt(int64(provider)), dagcbor.Encode)),
@@ -81,33 +152,117 @@ func (oc *OnChain) SubscribeDealActivatedEvent(ctx context.Context) {
 		}
 		filter.Fields["provider"] = providers
 	}
+	return filter, tracked, nil
+}
+
+// subscribe opens a subscription with the tracked providers, canceled by cancel
+func (oc *OnChain) subscribe(ctx context.Context) (<-chan *types.ActorEvent, context.CancelFunc, error) {
+	filter, providers, err := oc.filter(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	eventsChan, err := oc.lotusApi.SubscribeActorEventsRaw(sctx, filter)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	log.Infow("subscribed", "providers", providers)
+	return eventsChan, cancel, nil
+}
 
+func (oc *OnChain) SubscribeDealActivatedEvent(ctx context.Context) {
 	var eventsChan <-chan *types.ActorEvent
-	var err error
+	cancel := func() {}
+	defer func() {
+		cancel()
+	}()
 	for {
 		if eventsChan == nil {
 			log.Info("SubscribeDealActivatedEvent start ...")
-			eventsChan, err = oc.lotusApi.SubscribeActorEventsRaw(ctx, filter)
+			ch, c, err := oc.subscribe(ctx)
+			if errors.Is(err, errNoProviders) {
+				log.Warn("SubscribeDealActivatedEvent no provider enabled, wait for one")
+				oc.setStatus(func(s *Status) {
+					s.Subscribed = false
+				})
+				select {
+				case <-ctx.Done():
+					return
+				case <-oc.resubscribe:
+				}
+				continue
+			}
 			if err != nil {
 				log.Error(err)
-				time.Sleep(time.Second * 10)
+				oc.setStatus(func(s *Status) {
+					s.LastErr = err.Error()
+				})
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(10 * time.Second):
+				}
 				continue
 			}
+			eventsChan, cancel = ch, c
+			oc.setStatus(func(s *Status) {
+				s.Subscribed = true
+				s.SubscribedAt = time.Now()
+			})
 		}
 
 		select {
 		case event, ok := <-eventsChan:
 			if !ok {
 				log.Warn("SubscribeDealActivatedEvent channel closed")
+				oc.setStatus(func(s *Status) {
+					s.Subscribed = false
+					s.LastErr = "events channel closed"
+				})
 				eventsChan = nil
+				cancel()
 				continue
 			}
+			oc.setStatus(func(s *Status) {
+				s.LastEvent = time.Now()
+				s.LastEventHeight = event.Height
+				s.Events++
+			})
 			err := oc.process(ctx, event)
 			if err != nil {
 				log.Error(err)
+				oc.setStatus(func(s *Status) {
+					s.LastErr = err.Error()
+				})
+			}
+		case <-oc.resubscribe:
+			// the new subscription is opened before the old one is closed, the deals
+			// seen twice are upserted
+			ch, c, err := oc.subscribe(ctx)
+			if errors.Is(err, errNoProviders) {
+				// unsubscribe until a provider is enabled again
+				cancel()
+				cancel = func() {}
+				eventsChan = nil
+				log.Info("SubscribeDealActivatedEvent unsubscribed")
+				continue
+			}
+			if err != nil {
+				log.Errorw("resubscribe, keep the current subscription", "err", err)
+				continue
 			}
+			cancel()
+			eventsChan, cancel = ch, c
+			oc.setStatus(func(s *Status) {
+				s.SubscribedAt = time.Now()
+			})
+			log.Info("SubscribeDealActivatedEvent resubscribed")
 		case <-ctx.Done():
 			log.Warn("SubscribeDealActivatedEvent ctx done")
+			oc.setStatus(func(s *Status) {
+				s.Subscribed = false
+			})
 			return
 		}
 	}
@@ -171,8 +326,9 @@ func (oc *OnChain) process(ctx context.Context, event *types.ActorEvent) error {
 			return err
 		}
 
-		_, err = oc.repo.DB.ExecContext(ctx, `INSERT or IGNORE INTO Deals (deal_id, payload_cid, client, provider, start_epoch, end_epoch) VALUES ($1, $2, $3, $4, $5, $6)`,
-			id, label, deal.Proposal.Client.String(), deal.Proposal.Provider.String(), deal.Proposal.StartEpoch, deal.Proposal.EndEpoch)
+		_, err = oc.repo.DB.ExecContext(ctx, `INSERT INTO Deals (deal_id, payload_cid, client, provider, start_epoch, end_epoch, sector_start_epoch, last_updated_epoch, slash_epoch) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT(deal_id) DO UPDATE SET sector_start_epoch=excluded.sector_start_epoch, last_updated_epoch=excluded.last_updated_epoch, slash_epoch=excluded.slash_epoch`,
+			id, label, deal.Proposal.Client.String(), deal.Proposal.Provider.String(), deal.Proposal.StartEpoch, deal.Proposal.EndEpoch, deal.State.SectorStartEpoch, deal.State.LastUpdatedEpoch, deal.State.SlashEpoch)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a config written before the timeouts, retry, alert and report settings
+const oldConfig = `{
+	"lotus": ["eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http"],
+	"providers": ["f01000"],
+	"interval": "1m0s",
+	"parallel": 4
+}`
+
+func TestLoadOldConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fsConfig)
+	err := os.WriteFile(path, []byte(oldConfig), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := defaultConfig()
+	if c.Parallel != 4 || len(c.Providers) != 1 {
+		t.Fatalf("the file settings are lost: parallel %d providers %v", c.Parallel, c.Providers)
+	}
+	if c.Timeouts != def.Timeouts {
+		t.Fatalf("timeouts: got %+v, want the defaults %+v", c.Timeouts, def.Timeouts)
+	}
+	if c.Timeouts.FirstByte == 0 || c.Timeouts.Fetch == 0 || c.Timeouts.Run == 0 {
+		t.Fatalf("timeouts: got %+v, want bounds", c.Timeouts)
+	}
+	if c.Limit != def.Limit || c.ProviderParallel != def.ProviderParallel || c.Retry.Attempts != def.Retry.Attempts ||
+		c.Alert.Threshold != def.Alert.Threshold || c.Report.Runs != def.Report.Runs {
+		t.Fatalf("missing settings are not the defaults: %+v", c)
+	}
+}
@@ -5,11 +5,98 @@ CREATE TABLE IF NOT EXISTS Deals (
     provider TEXT,
     start_epoch INT,
     end_epoch INT,
+    sector_start_epoch INT,
+    last_updated_epoch INT,
+    slash_epoch INT,
 
     indexer_result TEXT,
+    indexer_peers TEXT,
     fetch_result TEXT,
     err_msg TEXT,
+    direct_result TEXT,
+    direct_protocols TEXT,
+    direct_err_msg TEXT,
     last_update DateTime,
   
     PRIMARY KEY(deal_id)
-);
\ No newline at end of file
+);
+
+CREATE TABLE IF NOT EXISTS Candidates (
+    deal_id INT NOT NULL,
+    peer_id TEXT NOT NULL,
+    addrs TEXT,
+    protocols TEXT,
+    metadata TEXT,
+    -- why the metadata is missing or invalid, such candidates are not fetched
+    metadata_err TEXT,
+
+    fetch_result TEXT,
+    err_msg TEXT,
+    last_update DateTime,
+
+    PRIMARY KEY(deal_id, peer_id)
+);
+
+CREATE TABLE IF NOT EXISTS IndexerResults (
+    deal_id INT NOT NULL,
+    indexer TEXT NOT NULL,
+    result TEXT,
+    records INT,
+    err_msg TEXT,
+    last_update DateTime,
+
+    PRIMARY KEY(deal_id, indexer)
+);
+
+CREATE TABLE IF NOT EXISTS Runs (
+    run_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT,
+    tasks INT,
+    result TEXT,
+    err_msg TEXT,
+    started_at DateTime,
+    finished_at DateTime
+);
+
+CREATE TABLE IF NOT EXISTS Attempts (
+    run_id INT,
+    deal_id INT NOT NULL,
+    provider TEXT,
+    attempt INT,
+    request TEXT,
+    indexer_result TEXT,
+    fetch_result TEXT,
+    direct_result TEXT,
+    err_class TEXT,
+    err_msg TEXT,
+    started_at DateTime,
+    duration_ms INT
+);
+
+CREATE INDEX IF NOT EXISTS index_attempts_deal_id on Attempts(deal_id);
+
+CREATE INDEX IF NOT EXISTS index_attempts_run_id on Attempts(run_id);
+
+CREATE TABLE IF NOT EXISTS AlertStates (
+    provider TEXT NOT NULL,
+    passing BOOLEAN,
+    rate REAL,
+    last_alert DateTime,
+    last_update DateTime,
+
+    PRIMARY KEY(provider)
+);
+
+CREATE TABLE IF NOT EXISTS Providers (
+    provider TEXT NOT NULL,
+    team TEXT,
+    label TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT 1,
+    deal_limit INT NOT NULL DEFAULT 0,
+    -- kept when removed, so the config providers are not added again
+    removed BOOLEAN NOT NULL DEFAULT 0,
+    created_at DateTime,
+    updated_at DateTime,
+
+    PRIMARY KEY(provider)
+);
@@ -31,3 +31,60 @@ func createDB(ctx context.Context, db *sql.DB) error {
 
 	return nil
 }
+
+// columns added after the first release, missing in DBs created by older versions
+var migrations = []struct {
+	table  string
+	column string
+	typ    string
+}{
+	{"Deals", "sector_start_epoch", "INT"},
+	{"Deals", "last_updated_epoch", "INT"},
+	{"Deals", "slash_epoch", "INT"},
+	{"Deals", "indexer_peers", "TEXT"},
+	{"Deals", "direct_result", "TEXT"},
+	{"Deals", "direct_protocols", "TEXT"},
+	{"Deals", "direct_err_msg", "TEXT"},
+	{"Attempts", "request", "TEXT"},
+	{"Providers", "removed", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"Candidates", "metadata_err", "TEXT"},
+}
+
+func migrateDB(ctx context.Context, db *sql.DB) error {
+	for _, m := range migrations {
+		exist, err := columnExists(ctx, db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exist {
+			continue
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.typ))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", m.table, m.column, err)
+		}
+		log.Infof("migrate: add column %s.%s", m.table, m.column)
+	}
+
+	return nil
+}
+
+func columnExists(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
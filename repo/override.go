@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnvPrefix prefixes the environment variables overriding the config
+const EnvPrefix = "RBOT_"
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// ConfigKeys returns the keys of the config which can be overridden, the JSON
+// field names joined by dots, eg: timeouts.firstByte
+func ConfigKeys() []string {
+	keys := []string{}
+	walkConfig(reflect.TypeOf(Config{}), "", func(key string, _ []int) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// EnvName is the environment variable of the config key, eg: RBOT_TIMEOUTS_FIRST_BYTE
+func EnvName(key string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for _, c := range key {
+		switch {
+		case c == '.':
+			b.WriteRune('_')
+		case unicode.IsUpper(c):
+			b.WriteRune('_')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(unicode.ToUpper(c))
+		}
+	}
+	return b.String()
+}
+
+// FlagName is the run flag of the config key, eg: timeouts-first-byte
+func FlagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(EnvName(key), EnvPrefix), "_", "-"))
+}
+
+// walkConfig calls f with the key and the field index of each leaf of the config,
+// nested structs are walked, slices of structs are leaves set as JSON
+func walkConfig(t reflect.Type, prefix string, f func(key string, index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			walkConfig(field.Type, key+".", func(k string, index []int) {
+				f(k, append([]int{i}, index...))
+			})
+			continue
+		}
+		f(key, []int{i})
+	}
+}
+
+// applyOverrides sets the config from the RBOT_* environment variables, then from
+// the overrides by key which take precedence
+func (c *Config) applyOverrides(overrides map[string]string) error {
+	v := reflect.ValueOf(c).Elem()
+	var err error
+	walkConfig(v.Type(), "", func(key string, index []int) {
+		if err != nil {
+			return
+		}
+		if env, ok := os.LookupEnv(EnvName(key)); ok {
+			err = setField(v.FieldByIndex(index), env)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", EnvName(key), err)
+				return
+			}
+		}
+		if o, ok := overrides[key]; ok {
+			err = setField(v.FieldByIndex(index), o)
+			if err != nil {
+				err = fmt.Errorf("--%s: %w", FlagName(key), err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for key := range overrides {
+		found := false
+		walkConfig(v.Type(), "", func(k string, _ []int) {
+			found = found || k == key
+		})
+		if !found {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+	}
+	return nil
+}
+
+// setField parses s into the field: durations as "1m30s", lists of strings comma
+// separated or as JSON, lists of structs as JSON
+func setField(f reflect.Value, s string) error {
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			list := []string{}
+			for _, e := range strings.Split(s, ",") {
+				if e = strings.TrimSpace(e); e != "" {
+					list = append(list, e)
+				}
+			}
+			f.Set(reflect.ValueOf(list))
+			return nil
+		}
+		p := reflect.New(f.Type())
+		err := json.Unmarshal([]byte(s), p.Interface())
+		if err != nil {
+			return err
+		}
+		f.Set(p.Elem())
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// Provider is a tracked storage provider, the deals of the enabled ones are followed on chain
+type Provider struct {
+	Provider string `json:"provider"`
+	Team     string `json:"team"`
+	Label    string `json:"label"`
+	Enabled  bool   `json:"enabled"`
+	// Limit overrides the deals retrieved per cron run if not zero
+	Limit     int       `json:"limit"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func checkProvider(p string) error {
+	a, err := address.NewFromString(p)
+	if err != nil {
+		return err
+	}
+	if a.Protocol() != address.ID {
+		return errors.New("not an ID address")
+	}
+	return nil
+}
+
+// OnProvidersChange registers f to be called after the tracked providers change
+func (r *Repo) OnProvidersChange(f func()) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.onProviders = append(r.onProviders, f)
+}
+
+func (r *Repo) providersChanged() {
+	r.lk.Lock()
+	hooks := r.onProviders
+	r.lk.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// seedProviders tracks the providers of the config which are not in the table yet,
+// and returns how many were added, the removed ones are not added again
+func (r *Repo) seedProviders(ctx context.Context, providers []string) (int, error) {
+	n := 0
+	for _, p := range providers {
+		ret, err := r.DB.ExecContext(ctx, `INSERT or IGNORE INTO Providers (provider, enabled, created_at, updated_at) VALUES ($1, 1, datetime('now'), datetime('now'))`, p)
+		if err != nil {
+			return n, err
+		}
+		added, err := ret.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += int(added)
+	}
+	return n, nil
+}
+
+func (r *Repo) Providers(ctx context.Context) ([]Provider, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT provider, COALESCE(team, ''), COALESCE(label, ''), enabled, deal_limit, created_at, updated_at FROM Providers WHERE NOT removed ORDER BY provider`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := []Provider{}
+	for rows.Next() {
+		var p Provider
+		err := rows.Scan(&p.Provider, &p.Team, &p.Label, &p.Enabled, &p.Limit, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, rows.Err()
+}
+
+// TrackAll tells whether no provider was ever added, then every provider is tracked.
+// Once one was, even disabled or removed, only the enabled ones are
+func (r *Repo) TrackAll(ctx context.Context) (bool, error) {
+	var all bool
+	err := r.DB.QueryRowContext(ctx, `SELECT NOT EXISTS (SELECT 1 FROM Providers)`).Scan(&all)
+	return all, err
+}
+
+// TrackedProviders returns the enabled providers, see TrackAll when it is empty
+func (r *Repo) TrackedProviders(ctx context.Context) ([]string, error) {
+	providers, err := r.Providers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tracked := []string{}
+	for _, p := range providers {
+		if p.Enabled {
+			tracked = append(tracked, p.Provider)
+		}
+	}
+	return tracked, nil
+}
+
+// ProviderUpdate holds the fields of a provider to set, the nil ones are left as they are,
+// or take their default when the provider is added: no team and label, enabled, no limit
+type ProviderUpdate struct {
+	Provider string  `json:"provider"`
+	Team     *string `json:"team,omitempty"`
+	Label    *string `json:"label,omitempty"`
+	Enabled  *bool   `json:"enabled,omitempty"`
+	Limit    *int    `json:"limit,omitempty"`
+}
+
+// ErrProviderExists is returned when adding a provider already tracked
+var ErrProviderExists = errors.New("provider already tracked")
+
+// ErrProviderNotFound is returned when updating or removing a provider not tracked
+var ErrProviderNotFound = errors.New("provider not tracked")
+
+func (u *ProviderUpdate) check() error {
+	err := checkProvider(u.Provider)
+	if err != nil {
+		return fmt.Errorf("provider %q: %w", u.Provider, err)
+	}
+	if u.Limit != nil && *u.Limit < 0 {
+		return fmt.Errorf("provider %s: negative limit", u.Provider)
+	}
+	return nil
+}
+
+// AddProvider tracks the provider, a removed one is tracked again with the fields of u
+func (r *Repo) AddProvider(ctx context.Context, u *ProviderUpdate) error {
+	err := u.check()
+	if err != nil {
+		return err
+	}
+	p := Provider{Provider: u.Provider, Enabled: true}
+	if u.Team != nil {
+		p.Team = *u.Team
+	}
+	if u.Label != nil {
+		p.Label = *u.Label
+	}
+	if u.Enabled != nil {
+		p.Enabled = *u.Enabled
+	}
+	if u.Limit != nil {
+		p.Limit = *u.Limit
+	}
+
+	ret, err := r.DB.ExecContext(ctx, `INSERT INTO Providers (provider, team, label, enabled, deal_limit, removed, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, 0, datetime('now'), datetime('now'))
+		ON CONFLICT(provider) DO UPDATE SET team=excluded.team, label=excluded.label, enabled=excluded.enabled, deal_limit=excluded.deal_limit, removed=0, created_at=excluded.created_at, updated_at=excluded.updated_at WHERE removed`,
+		p.Provider, p.Team, p.Label, p.Enabled, p.Limit)
+	if err != nil {
+		return err
+	}
+	n, err := ret.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", p.Provider, ErrProviderExists)
+	}
+	log.Infow("provider added", "provider", p.Provider, "team", p.Team, "label", p.Label, "enabled", p.Enabled, "limit", p.Limit)
+	r.providersChanged()
+	return nil
+}
+
+// UpdateProvider sets the fields of u on a tracked provider
+func (r *Repo) UpdateProvider(ctx context.Context, u *ProviderUpdate) error {
+	err := u.check()
+	if err != nil {
+		return err
+	}
+
+	ret, err := r.DB.ExecContext(ctx, `UPDATE Providers SET team=COALESCE($1, team), label=COALESCE($2, label), enabled=COALESCE($3, enabled), deal_limit=COALESCE($4, deal_limit), updated_at=datetime('now') WHERE provider=$5 AND NOT removed`,
+		u.Team, u.Label, u.Enabled, u.Limit, u.Provider)
+	if err != nil {
+		return err
+	}
+	n, err := ret.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", u.Provider, ErrProviderNotFound)
+	}
+	log.Infow("provider updated", "provider", u.Provider)
+	r.providersChanged()
+	return nil
+}
+
+// RemoveProvider stops tracking the provider, its deals are kept
+func (r *Repo) RemoveProvider(ctx context.Context, provider string) error {
+	ret, err := r.DB.ExecContext(ctx, `UPDATE Providers SET removed=1, updated_at=datetime('now') WHERE provider=$1 AND NOT removed`, provider)
+	if err != nil {
+		return err
+	}
+	n, err := ret.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", provider, ErrProviderNotFound)
+	}
+	log.Infow("provider removed", "provider", provider)
+	r.providersChanged()
+	return nil
+}
+
+// ProvidersHandler lists the providers on GET /providers, adds one on POST and updates
+// one on PATCH with a JSON ProviderUpdate, and removes one on DELETE /providers?provider=
+func (r *Repo) ProvidersHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		providers, err := r.Providers(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(providers)
+	case http.MethodPost, http.MethodPatch:
+		var u ProviderUpdate
+		err := json.NewDecoder(req.Body).Decode(&u)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Method == http.MethodPost {
+			err = r.AddProvider(req.Context(), &u)
+		} else {
+			err = r.UpdateProvider(req.Context(), &u)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), providerStatus(err))
+			return
+		}
+	case http.MethodDelete:
+		err := r.RemoveProvider(req.Context(), req.URL.Query().Get("provider"))
+		if err != nil {
+			http.Error(w, err.Error(), providerStatus(err))
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func providerStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrProviderExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrProviderNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
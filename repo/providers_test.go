@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newTestRepo(t *testing.T, providers string) *Repo {
+	t.Helper()
+	dir := t.TempDir()
+	err := Init(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir, map[string]string{
+		"lotus":     "eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http",
+		"providers": providers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func provider(t *testing.T, r *Repo, id string) *Provider {
+	t.Helper()
+	providers, err := r.Providers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range providers {
+		if p.Provider == id {
+			return &p
+		}
+	}
+	return nil
+}
+
+func TestProviderUpdate(t *testing.T) {
+	r := newTestRepo(t, "")
+	ctx := context.Background()
+
+	team, limit := "a", 5
+	err := r.AddProvider(ctx, &ProviderUpdate{Provider: "f01000", Team: &team, Limit: &limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := provider(t, r, "f01000")
+	if p == nil || !p.Enabled || p.Team != "a" || p.Limit != 5 {
+		t.Fatalf("added: got %+v, want enabled, team a and limit 5", p)
+	}
+	err = r.AddProvider(ctx, &ProviderUpdate{Provider: "f01000"})
+	if !errors.Is(err, ErrProviderExists) {
+		t.Fatalf("added twice: got %v, want %v", err, ErrProviderExists)
+	}
+
+	label := "l"
+	err = r.UpdateProvider(ctx, &ProviderUpdate{Provider: "f01000", Label: &label})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = provider(t, r, "f01000")
+	if !p.Enabled || p.Team != "a" || p.Label != "l" || p.Limit != 5 {
+		t.Fatalf("updated: got %+v, want only the label set", p)
+	}
+
+	err = r.UpdateProvider(ctx, &ProviderUpdate{Provider: "f01001", Label: &label})
+	if !errors.Is(err, ErrProviderNotFound) {
+		t.Fatalf("update untracked: got %v, want %v", err, ErrProviderNotFound)
+	}
+}
+
+func TestRemovedProviderNotSeeded(t *testing.T) {
+	r := newTestRepo(t, "f01000,f01001")
+	ctx := context.Background()
+
+	err := r.RemoveProvider(ctx, "f01000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked, err := r.TrackedProviders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracked) != 1 || tracked[0] != "f01001" {
+		t.Fatalf("tracked after reload: got %v, want [f01001]", tracked)
+	}
+
+	err = r.AddProvider(ctx, &ProviderUpdate{Provider: "f01000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := provider(t, r, "f01000"); p == nil || !p.Enabled {
+		t.Fatalf("added again: got %+v, want enabled", p)
+	}
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+)
+
+// AddValidator registers a check of the config, run on every reload, for the
+// settings only known by the package using them
+func (r *Repo) AddValidator(v func(*Config) error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.validators = append(r.validators, v)
+}
+
+// OnReload registers f to be called with the old and the new config after each reload
+func (r *Repo) OnReload(f func(old, new *Config)) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.onReload = append(r.onReload, f)
+}
+
+// Reload reads the config file again and swaps it in with the same overrides,
+// the current config is kept if the new one is invalid
+func (r *Repo) Reload() error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	conf, err := loadConfig(filepath.Join(r.path, fsConfig), r.overrides)
+	if err != nil {
+		return err
+	}
+	for _, v := range r.validators {
+		err = v(conf)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	old := r.conf.Swap(conf)
+	if !slices.Equal(old.Lotus, conf.Lotus) || old.ScratchDir != conf.ScratchDir || !slices.Equal(old.Indexers, conf.Indexers) || old.Timeouts.FirstByte != conf.Timeouts.FirstByte {
+		log.Warn("lotus, scratchDir, indexers and timeouts.firstByte changes need a restart")
+	}
+	for _, f := range r.onReload {
+		f(old, conf)
+	}
+	log.Infow("config reloaded", "providers", conf.Providers, "schedules", len(conf.Schedules))
+
+	added, err := r.seedProviders(context.Background(), conf.Providers)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		// the hooks take the lock of the repo
+		go r.providersChanged()
+	}
+	return nil
+}
+
+// ReloadHandler reloads the config at POST /admin/reload
+func (r *Repo) ReloadHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := r.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
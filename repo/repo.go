@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/mitchellh/go-homedir"
@@ -16,21 +18,32 @@ const (
 	fsDB         = "rbot.db"
 	fsConfig     = "config.json"
 	fsMarketDeal = "StateMarketDeals.json.zst"
+	fsCars       = "cars"
+	fsReports    = "reports"
 )
 
 type Repo struct {
 	path string
 	DB   *sql.DB
-	Conf *Config
+	// overrides of the config by key, kept to be applied again on reload
+	overrides map[string]string
+	// the config is swapped as a whole on reload, read it through Conf
+	conf        atomic.Pointer[Config]
+	lk          sync.Mutex
+	validators  []func(*Config) error
+	onReload    []func(old, new *Config)
+	onProviders []func()
 }
 
-func New(path string) (*Repo, error) {
+// New opens the repo, overrides set config keys (see ConfigKeys) over the config
+// file and the RBOT_* environment variables
+func New(path string, overrides map[string]string) (*Repo, error) {
 	path, err := homedir.Expand(path)
 	if err != nil {
 		return nil, err
 	}
 
-	conf, err := loadConfig(filepath.Join(path, fsConfig))
+	conf, err := loadConfig(filepath.Join(path, fsConfig), overrides)
 	if err != nil {
 		return nil, err
 	}
@@ -40,17 +53,57 @@ func New(path string) (*Repo, error) {
 		return nil, err
 	}
 
-	return &Repo{
-		path: path,
-		DB:   db,
-		Conf: conf,
-	}, nil
+	ctx := context.Background()
+	err = createDB(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	err = migrateDB(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Repo{
+		path:      path,
+		DB:        db,
+		overrides: overrides,
+	}
+	r.conf.Store(conf)
+
+	_, err = r.seedProviders(ctx, conf.Providers)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the DB, once nothing writes to it anymore
+func (r *Repo) Close() error {
+	return r.DB.Close()
+}
+
+// Conf returns the current config, which must not be modified
+func (r *Repo) Conf() *Config {
+	return r.conf.Load()
 }
 
 func (r *Repo) StorageMarketDealFile() string {
 	return filepath.Join(r.path, fsMarketDeal)
 }
 
+// OutputDir is where the verified CARs of the manual retrievals are written
+func (r *Repo) OutputDir() string {
+	if r.Conf().OutputDir != "" {
+		return r.Conf().OutputDir
+	}
+	return filepath.Join(r.path, fsCars)
+}
+
+// ReportDir is where the retrieval reports are written
+func (r *Repo) ReportDir() string {
+	return filepath.Join(r.path, fsReports)
+}
+
 func Init(ctx context.Context, path string) error {
 	path, err := homedir.Expand(path)
 	if err != nil {
@@ -0,0 +1,43 @@
+package repo
+
+// results stored in the indexer_result, fetch_result and direct_result columns of the
+// deals, attempts and candidates
+const (
+	ResultOK  = "OK"
+	ResultErr = "ERR"
+	// the attempt was aborted by a deadline, a cancellation or a shutdown
+	ResultTimeout     = "TIMEOUT"
+	ResultCanceled    = "CANCELED"
+	ResultInterrupted = "INTERRUPTED"
+)
+
+// indexer results of a deal, besides OK, ERR and the aborted ones
+const (
+	// the payload is not in any indexer
+	IndexerNotIndexed = "NOT_INDEXED"
+	// the payload is only indexed at other peers
+	IndexerOtherPeers = "OTHER_PEERS"
+	// the payload is indexed at the addresses of the miner, but under another peer ID
+	IndexerPeerMismatch = "PEER_MISMATCH"
+	// the payload is indexed at the miner peer, but with missing or invalid metadata
+	IndexerInvalidMetadata = "INVALID_METADATA"
+	// the miner info on chain has no peer ID
+	IndexerNoPeerID = "NO_PEER_ID"
+	// the result of one indexer which has no record of the payload
+	IndexerNotFound = "NOT_FOUND"
+)
+
+// direct results of a deal, besides OK, ERR, NO_PEER_ID and the aborted ones
+const (
+	DirectNoAddrs     = "NO_ADDRS"
+	DirectNoProtocols = "NO_PROTOCOLS"
+	DirectDialErr     = "DIAL_ERR"
+)
+
+// Succeeded is the SQL condition of a deal or an attempt which got the payload, through the
+// indexer or directly from the miner. A deal holds the results of its last attempt, the
+// phases the attempt did not reach are NULL
+const Succeeded = `(COALESCE(fetch_result, '') = '` + ResultOK + `' OR COALESCE(direct_result, '') = '` + ResultOK + `')`
+
+// Failed is the SQL condition of a deal or an attempt which did not get the payload
+const Failed = `NOT ` + Succeeded
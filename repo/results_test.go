@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSucceededFailed(t *testing.T) {
+	r := newTestRepo(t, "")
+
+	// indexer and fetch OK, direct only OK, fallback OK, indexer failed, direct only failed
+	_, err := r.DB.Exec(`INSERT INTO Deals (deal_id, payload_cid, client, provider, start_epoch, end_epoch, indexer_result, fetch_result, direct_result) VALUES
+		(1, 'p', 'f01', 'f02', 0, 0, 'OK', 'OK', NULL),
+		(2, 'p', 'f01', 'f02', 0, 0, NULL, NULL, 'OK'),
+		(3, 'p', 'f01', 'f02', 0, 0, 'NOT_INDEXED', NULL, 'OK'),
+		(4, 'p', 'f01', 'f02', 0, 0, 'NOT_INDEXED', NULL, NULL),
+		(5, 'p', 'f01', 'f02', 0, 0, NULL, NULL, 'DIAL_ERR')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for cond, want := range map[string][]int64{
+		Succeeded: {1, 2, 3},
+		Failed:    {4, 5},
+	} {
+		rows, err := r.DB.QueryContext(context.Background(), `SELECT deal_id FROM Deals WHERE `+cond+` ORDER BY deal_id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int64{}
+		for rows.Next() {
+			var id int64
+			err = rows.Scan(&id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, id)
+		}
+		rows.Close()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %v, want %v", cond, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s: got %v, want %v", cond, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/filecoin-project/go-address"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/robfig/cron/v3"
+)
+
+// Validate checks the config as a whole and returns every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(len(c.Lotus) > 0, "lotus: no API info, set it in the config file, %s or --%s", EnvName("lotus"), FlagName("lotus"))
+	for i, l := range c.Lotus {
+		_, err := cliutil.ParseApiInfo(l).DialArgs("v1")
+		check(err == nil, "lotus[%d]: %v", i, err)
+	}
+	for i, p := range c.Providers {
+		a, err := address.NewFromString(p)
+		if err == nil && a.Protocol() != address.ID {
+			err = errors.New("not an ID address")
+		}
+		check(err == nil, "providers[%d] %q: %v", i, p, err)
+	}
+
+	check(c.Interval >= 0, "interval: negative")
+	check(c.Parallel > 0, "parallel: must be positive")
+	check(c.Limit > 0, "limit: must be positive")
+	check(c.ProviderParallel >= 0, "providerParallel: negative")
+	check(c.Bandwidth >= 0, "bandwidth: negative")
+	check(c.ScratchLimit >= 0, "scratchLimit: negative")
+	for i, ix := range c.Indexers {
+		check(validURL(ix), "indexers[%d] %q: not an http(s) URL", i, ix)
+	}
+
+	check(c.Timeouts.MinerInfo >= 0 && c.Timeouts.Indexer >= 0 && c.Timeouts.FirstByte >= 0 && c.Timeouts.Fetch >= 0 && c.Timeouts.Run >= 0, "timeouts: negative")
+	check(c.Retry.Attempts >= 0, "retry.attempts: negative")
+	check(c.Retry.Backoff >= 0 && c.Retry.MaxBackoff >= 0, "retry: negative backoff")
+	for i, class := range c.Retry.Classes {
+		check(class == "timeout" || class == "dial" || class == "other", "retry.classes[%d] %q: only timeout, dial and other are retried", i, class)
+	}
+
+	for i, s := range c.Schedules {
+		_, err := cron.ParseStandard(s.Cron)
+		check(err == nil, "schedules[%d] %q: %v", i, s.Cron, err)
+		check(s.Limit >= 0, "schedules[%d]: negative limit", i)
+	}
+
+	check(c.Alert.Threshold >= 0 && c.Alert.Threshold <= 1, "alert.threshold: must be within 0 and 1")
+	check(c.Alert.MinTested >= 0, "alert.minTested: negative")
+	check(c.Alert.Cooldown >= 0, "alert.cooldown: negative")
+	errs = append(errs, validateWebhooks("alert", c.Alert.Webhooks)...)
+
+	for _, spec := range []struct{ name, cron string }{{"report.daily", c.Report.Daily}, {"report.weekly", c.Report.Weekly}} {
+		if spec.cron == "" {
+			continue
+		}
+		_, err := cron.ParseStandard(spec.cron)
+		check(err == nil, "%s %q: %v", spec.name, spec.cron, err)
+	}
+	errs = append(errs, validateWebhooks("report", c.Report.Webhooks)...)
+
+	return errors.Join(errs...)
+}
+
+func validateWebhooks(section string, webhooks []Webhook) []error {
+	var errs []error
+	for i, wh := range webhooks {
+		if !validURL(wh.URL) {
+			errs = append(errs, fmt.Errorf("%s.webhooks[%d] %q: not an http(s) URL", section, i, wh.URL))
+		}
+		if wh.Format != "" && wh.Format != "slack" && wh.Format != "json" {
+			errs = append(errs, fmt.Errorf("%s.webhooks[%d]: unknown format %q", section, i, wh.Format))
+		}
+	}
+	return errs
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,448 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/gh-efforts/rbot/retrieve"
+	"github.com/gh-efforts/rbot/webhook"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/robfig/cron/v3"
+)
+
+//go:embed templates/*
+var tmplFS embed.FS
+
+var log = logging.Logger("report")
+
+var tmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+}).ParseFS(tmplFS, "templates/*.html"))
+
+// kinds of report
+const (
+	kindRun    = "run"
+	kindDaily  = "daily"
+	kindWeekly = "weekly"
+)
+
+// the deals of a run are judged on their last attempt
+const lastAttempt = `attempt = (SELECT MAX(attempt) FROM Attempts WHERE run_id=a.run_id AND deal_id=a.deal_id)`
+
+// the deals of a period are judged on their last attempt of a cron run in the period, the manual
+// and ad-hoc runs pick deals by hand, they say little of the providers
+const lastCronAttempt = `a.rowid = (SELECT b.rowid FROM Attempts b JOIN Runs r ON r.run_id = b.run_id
+	WHERE b.deal_id = a.deal_id AND r.kind = '` + retrieve.RunCron + `' AND b.started_at >= ? AND b.started_at < ?
+	ORDER BY b.started_at DESC, b.rowid DESC LIMIT 1)`
+
+const succeeded = `CASE WHEN ` + repo.Succeeded + ` THEN 1 ELSE 0 END`
+
+type Reporter struct {
+	repo *repo.Repo
+
+	// the period reports, replaced when the schedules are reloaded
+	cronLk  sync.Mutex
+	cron    *cron.Cron
+	cronCtx context.Context
+}
+
+type Report struct {
+	Name      string           `json:"name"`
+	Kind      string           `json:"kind"`
+	RunID     int64            `json:"runID,omitempty"`
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Total     Rate             `json:"total"`
+	Previous  *Rate            `json:"previous,omitempty"`
+	Providers []ProviderReport `json:"providers"`
+	TopErrors []ErrorCount     `json:"topErrors"`
+}
+
+// Rate counts the deals tested and the ones retrieved
+type Rate struct {
+	Tested    int     `json:"tested"`
+	Succeeded int     `json:"succeeded"`
+	Rate      float64 `json:"rate"`
+}
+
+type ProviderReport struct {
+	Provider  string  `json:"provider"`
+	Tested    int     `json:"tested"`
+	Succeeded int     `json:"succeeded"`
+	Rate      float64 `json:"rate"`
+	// Previous is the rate of the provider in the previous run or period, if it was tested
+	Previous *Rate `json:"previous,omitempty"`
+	// Change of the rate since the previous run or period
+	Change float64 `json:"change"`
+}
+
+// ErrorCount counts the deals failed the same way, Sample is one of their error messages
+type ErrorCount struct {
+	IndexerResult string `json:"indexerResult"`
+	FetchResult   string `json:"fetchResult"`
+	DirectResult  string `json:"directResult"`
+	ErrClass      string `json:"errClass"`
+	Count         int    `json:"count"`
+	Sample        string `json:"sample"`
+}
+
+// window selects the attempts of a run or of a period, one per deal
+type window struct {
+	cond string
+	args []any
+}
+
+func runWindow(runID int64) window {
+	return window{cond: `run_id = ? AND ` + lastAttempt, args: []any{runID}}
+}
+
+func periodWindow(from, to time.Time) window {
+	f, t := from.UTC().Format(time.DateTime), to.UTC().Format(time.DateTime)
+	return window{cond: `started_at >= ? AND started_at < ? AND ` + lastCronAttempt, args: []any{f, t, f, t}}
+}
+
+func New(repo *repo.Repo) *Reporter {
+	rp := &Reporter{repo: repo}
+	repo.OnReload(rp.reload)
+	return rp
+}
+
+// Run generates the daily and weekly reports on the schedules of the config
+func (rp *Reporter) Run(ctx context.Context) {
+	rp.cronLk.Lock()
+	defer rp.cronLk.Unlock()
+
+	err := rp.schedule(ctx, rp.repo.Conf().Report)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Stop stops the period reports and waits for the ones in progress
+func (rp *Reporter) Stop() {
+	rp.cronLk.Lock()
+	defer rp.cronLk.Unlock()
+	if rp.cron == nil {
+		return
+	}
+	<-rp.cron.Stop().Done()
+	// reloads no longer reschedule
+	rp.cron = nil
+}
+
+func (rp *Reporter) reload(old, new *repo.Config) {
+	if old.Report.Daily == new.Report.Daily && old.Report.Weekly == new.Report.Weekly {
+		return
+	}
+	rp.cronLk.Lock()
+	defer rp.cronLk.Unlock()
+	// not running yet, Run will use the new schedules
+	if rp.cron == nil {
+		return
+	}
+	err := rp.schedule(rp.cronCtx, new.Report)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// schedule replaces the period reports with the schedules of conf
+func (rp *Reporter) schedule(ctx context.Context, conf repo.Report) error {
+	c := cron.New()
+	periods := []struct {
+		kind   string
+		spec   string
+		period time.Duration
+	}{
+		{kindDaily, conf.Daily, 24 * time.Hour},
+		{kindWeekly, conf.Weekly, 7 * 24 * time.Hour},
+	}
+	for _, p := range periods {
+		if p.spec == "" {
+			continue
+		}
+		p := p
+		_, err := c.AddFunc(p.spec, func() {
+			err := rp.PeriodReport(ctx, p.kind, p.period)
+			if err != nil {
+				log.Error(err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("report %s schedule %q: %w", p.kind, p.spec, err)
+		}
+	}
+
+	if rp.cron != nil {
+		rp.cron.Stop()
+	}
+	rp.cron, rp.cronCtx = c, ctx
+	c.Start()
+	log.Infow("report schedules", "daily", conf.Daily, "weekly", conf.Weekly)
+	return nil
+}
+
+// RunReport reports the finished run, if the run reports are enabled
+func (rp *Reporter) RunReport(ctx context.Context, runID int64) {
+	if !rp.repo.Conf().Report.Runs {
+		return
+	}
+
+	var kind string
+	var started, finished sql.NullTime
+	err := rp.repo.DB.QueryRowContext(ctx, `SELECT kind, started_at, finished_at FROM Runs WHERE run_id=$1`, runID).Scan(&kind, &started, &finished)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	r := &Report{
+		Name:  fmt.Sprintf("run-%d", runID),
+		Kind:  kindRun,
+		RunID: runID,
+		From:  started.Time,
+		To:    finished.Time,
+	}
+
+	// the previous run of the same kind which tested deals
+	var prev *window
+	var prevID int64
+	err = rp.repo.DB.QueryRowContext(ctx, `SELECT run_id FROM Runs WHERE kind=$1 AND run_id < $2 AND tasks > 0 ORDER BY run_id DESC LIMIT 1`, kind, runID).Scan(&prevID)
+	if err == nil {
+		w := runWindow(prevID)
+		prev = &w
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Error(err)
+		return
+	}
+
+	err = rp.generate(ctx, r, runWindow(runID), prev)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// PeriodReport reports the attempts of the period up to now, compared to the period before
+func (rp *Reporter) PeriodReport(ctx context.Context, kind string, period time.Duration) error {
+	to := time.Now().Truncate(time.Minute)
+	from := to.Add(-period)
+	r := &Report{
+		Name: fmt.Sprintf("%s-%s", kind, to.Format(time.DateOnly)),
+		Kind: kind,
+		From: from,
+		To:   to,
+	}
+	prev := periodWindow(from.Add(-period), from)
+	return rp.generate(ctx, r, periodWindow(from, to), &prev)
+}
+
+// generate fills the report with the attempts of the window, compared to the previous one,
+// writes it and pushes it to the webhooks
+func (rp *Reporter) generate(ctx context.Context, r *Report, w window, prev *window) error {
+	rates, err := rp.rates(ctx, w)
+	if err != nil {
+		return err
+	}
+	prevRates := map[string]Rate{}
+	if prev != nil {
+		prevRates, err = rp.rates(ctx, *prev)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.Providers = []ProviderReport{}
+	var prevTotal Rate
+	for p, rate := range rates {
+		r.Total.Tested += rate.Tested
+		r.Total.Succeeded += rate.Succeeded
+		pr := ProviderReport{Provider: p, Tested: rate.Tested, Succeeded: rate.Succeeded, Rate: rate.Rate}
+		if pv, ok := prevRates[p]; ok {
+			pr.Previous = &pv
+			pr.Change = rate.Rate - pv.Rate
+		}
+		r.Providers = append(r.Providers, pr)
+	}
+	sort.Slice(r.Providers, func(i, j int) bool {
+		return r.Providers[i].Provider < r.Providers[j].Provider
+	})
+	r.Total.Rate = rateOf(r.Total.Tested, r.Total.Succeeded)
+	for _, pv := range prevRates {
+		prevTotal.Tested += pv.Tested
+		prevTotal.Succeeded += pv.Succeeded
+	}
+	if prevTotal.Tested > 0 {
+		prevTotal.Rate = rateOf(prevTotal.Tested, prevTotal.Succeeded)
+		r.Previous = &prevTotal
+	}
+
+	r.TopErrors, err = rp.topErrors(ctx, w)
+	if err != nil {
+		return err
+	}
+
+	err = rp.write(r)
+	if err != nil {
+		return err
+	}
+	log.Infow("report", "name", r.Name, "tested", r.Total.Tested, "rate", r.Total.Rate)
+
+	text := fmt.Sprintf("rbot %s report %s: %d/%d deals retrieved (%.1f%%) across %d providers", r.Kind, r.Name, r.Total.Succeeded, r.Total.Tested, r.Total.Rate*100, len(r.Providers))
+	for _, wh := range rp.repo.Conf().Report.Webhooks {
+		err := webhook.Post(ctx, wh, text, r)
+		if err != nil {
+			log.Errorw("webhook", "url", wh.URL, "err", err)
+		}
+	}
+	return nil
+}
+
+func rateOf(tested, succeeded int) float64 {
+	if tested == 0 {
+		return 0
+	}
+	return float64(succeeded) / float64(tested)
+}
+
+func (rp *Reporter) rates(ctx context.Context, w window) (map[string]Rate, error) {
+	rows, err := rp.repo.DB.QueryContext(ctx, `SELECT provider, COUNT(*), SUM(`+succeeded+`) FROM Attempts a WHERE `+w.cond+` GROUP BY provider`, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := map[string]Rate{}
+	for rows.Next() {
+		var provider string
+		var r Rate
+		err := rows.Scan(&provider, &r.Tested, &r.Succeeded)
+		if err != nil {
+			return nil, err
+		}
+		r.Rate = rateOf(r.Tested, r.Succeeded)
+		rates[provider] = r
+	}
+	return rates, rows.Err()
+}
+
+func (rp *Reporter) topErrors(ctx context.Context, w window) ([]ErrorCount, error) {
+	rows, err := rp.repo.DB.QueryContext(ctx, `SELECT COALESCE(indexer_result, ''), COALESCE(fetch_result, ''), COALESCE(direct_result, ''), COALESCE(err_class, ''), COUNT(*), COALESCE(MAX(err_msg), '')
+		FROM Attempts a WHERE `+w.cond+` AND `+succeeded+` = 0
+		GROUP BY 1, 2, 3, 4 ORDER BY 5 DESC LIMIT 10`, w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	errs := []ErrorCount{}
+	for rows.Next() {
+		var ec ErrorCount
+		err := rows.Scan(&ec.IndexerResult, &ec.FetchResult, &ec.DirectResult, &ec.ErrClass, &ec.Count, &ec.Sample)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, ec)
+	}
+	return errs, rows.Err()
+}
+
+// write saves the report as <name>.html, .csv and .json in the report dir
+func (rp *Reporter) write(r *Report) error {
+	dir := rp.repo.ReportDir()
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(dir, r.Name)
+
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(base+".json", data, 0644)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	cw := csv.NewWriter(&sb)
+	cw.Write([]string{"provider", "tested", "succeeded", "rate", "previous_rate", "change"})
+	for _, p := range r.Providers {
+		prev := ""
+		if p.Previous != nil {
+			prev = strconv.FormatFloat(p.Previous.Rate, 'f', 4, 64)
+		}
+		cw.Write([]string{p.Provider, strconv.Itoa(p.Tested), strconv.Itoa(p.Succeeded), strconv.FormatFloat(p.Rate, 'f', 4, 64), prev, strconv.FormatFloat(p.Change, 'f', 4, 64)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	err = os.WriteFile(base+".csv", []byte(sb.String()), 0644)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(base + ".html")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return tmpl.ExecuteTemplate(f, "report.html", r)
+}
+
+// Reports lists the reports at /reports and serves their files under it
+func (rp *Reporter) Reports(w http.ResponseWriter, req *http.Request) {
+	dir := rp.repo.ReportDir()
+	name := strings.TrimPrefix(req.URL.Path, "/reports")
+	if name != "" && name != "/" {
+		http.StripPrefix("/reports", http.FileServer(http.Dir(dir))).ServeHTTP(w, req)
+		return
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type entry struct {
+		name    string
+		modTime time.Time
+	}
+	entries := []entry{}
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{strings.TrimSuffix(filepath.Base(m), ".json"), fi.ModTime()})
+	}
+	// newest first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.After(entries[j].modTime)
+	})
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+
+	err = tmpl.ExecuteTemplate(w, "reports.html", names)
+	if err != nil {
+		log.Error(err)
+	}
+}
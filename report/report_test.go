@@ -0,0 +1,117 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+func newTestRepo(t *testing.T) (*repo.Repo, string) {
+	t.Helper()
+	dir := t.TempDir()
+	err := repo.Init(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.New(dir, map[string]string{"lotus": "eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, dir
+}
+
+func TestReloadSchedules(t *testing.T) {
+	r, dir := newTestRepo(t)
+
+	rp := New(r)
+	rp.Run(context.Background())
+	if n := len(rp.cron.Entries()); n != 2 {
+		t.Fatalf("entries: got %d, want the daily and weekly reports", n)
+	}
+
+	conf := *r.Conf()
+	conf.Report.Weekly = ""
+	raw, err := json.Marshal(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "config.json"), raw, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp.cronLk.Lock()
+	defer rp.cronLk.Unlock()
+	if n := len(rp.cron.Entries()); n != 1 {
+		t.Fatalf("entries after reload: got %d, want the daily report", n)
+	}
+}
+
+func TestPeriodRates(t *testing.T) {
+	r, _ := newTestRepo(t)
+	rp := New(r)
+
+	now := time.Now().UTC()
+	at := func(ago time.Duration) string {
+		return now.Add(-ago).Format(time.DateTime)
+	}
+	for _, q := range []string{
+		`INSERT INTO Runs (run_id, kind, tasks, started_at) VALUES (1, 'cron', 2, '` + at(3*time.Hour) + `'), (2, 'cron', 1, '` + at(2*time.Hour) + `'), (3, 'manual', 1, '` + at(time.Hour) + `')`,
+		// deal 1 failed then succeeded in the next cron run, deal 2 failed, the manual run is ignored
+		`INSERT INTO Attempts (run_id, deal_id, provider, attempt, fetch_result, started_at) VALUES
+			(1, 1, 'f01000', 1, 'ERR', '` + at(3*time.Hour) + `'),
+			(1, 2, 'f01000', 1, 'ERR', '` + at(3*time.Hour) + `'),
+			(2, 1, 'f01000', 1, 'OK', '` + at(2*time.Hour) + `'),
+			(3, 2, 'f01000', 1, 'OK', '` + at(time.Hour) + `')`,
+	} {
+		_, err := r.DB.Exec(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rates, err := rp.rates(context.Background(), periodWindow(now.Add(-24*time.Hour), now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rates["f01000"]
+	if got.Tested != 2 || got.Succeeded != 1 {
+		t.Fatalf("rates: got %+v, want 2 deals tested and 1 succeeded", got)
+	}
+}
+
+func TestStop(t *testing.T) {
+	r, dir := newTestRepo(t)
+	rp := New(r)
+	rp.Run(context.Background())
+	rp.Stop()
+
+	// the reloads no longer reschedule
+	conf := *r.Conf()
+	conf.Report.Weekly = ""
+	raw, err := json.Marshal(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "config.json"), raw, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.cron != nil {
+		t.Fatal("rescheduled after stop")
+	}
+}
@@ -0,0 +1,242 @@
+package retrieve
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DiagnoseParam is an ad-hoc retrieval of a deal, or of a payload from a provider
+type DiagnoseParam struct {
+	DealID int64 `json:"dealID"`
+	// PayloadCID and Provider are used if DealID is zero, the result is
+	// recorded on the latest deal of the payload with the provider if any
+	PayloadCID string `json:"payloadCID"`
+	Provider   string `json:"provider"`
+	Direct     string `json:"direct"`
+	Path       string `json:"path"`
+	Scope      string `json:"scope"`
+	Bytes      string `json:"bytes"`
+}
+
+// Diagnosis is everything an ad-hoc retrieval went through
+type Diagnosis struct {
+	DealID          int64                `json:"dealID"`
+	PayloadCID      string               `json:"payloadCID"`
+	Provider        string               `json:"provider"`
+	Request         string               `json:"request"`
+	MinerInfo       *MinerInfo           `json:"minerInfo,omitempty"`
+	Indexers        []IndexerDiagnosis   `json:"indexers"`
+	Candidates      []CandidateDiagnosis `json:"candidates"`
+	IndexerResult   string               `json:"indexerResult"`
+	FetchResult     string               `json:"fetchResult"`
+	DirectResult    string               `json:"directResult"`
+	DirectProtocols string               `json:"directProtocols"`
+	Stats           *FetchStats          `json:"stats,omitempty"`
+	Took            string               `json:"took"`
+	Err             string               `json:"err"`
+}
+
+type MinerInfo struct {
+	PeerID     string   `json:"peerID"`
+	Multiaddrs []string `json:"multiaddrs"`
+}
+
+type IndexerDiagnosis struct {
+	Endpoint string `json:"endpoint"`
+	Result   string `json:"result"`
+	Records  int    `json:"records"`
+	Err      string `json:"err"`
+}
+
+type CandidateDiagnosis struct {
+	PeerID    string   `json:"peerID"`
+	Addrs     []string `json:"addrs"`
+	Protocols []string `json:"protocols"`
+	Err       string   `json:"err"`
+}
+
+type FetchStats struct {
+	Provider     string `json:"provider"`
+	Size         uint64 `json:"size"`
+	Blocks       uint64 `json:"blocks"`
+	Duration     string `json:"duration"`
+	FirstByte    string `json:"firstByte"`
+	AverageSpeed uint64 `json:"averageSpeed"`
+}
+
+// Diagnose retrieves a deal or a payload once, synchronously, and returns the diagnosis
+func (r *Retrieve) Diagnose(w http.ResponseWriter, req *http.Request) {
+	var dp DiagnoseParam
+	err := json.NewDecoder(req.Body).Decode(&dp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dp.Direct == directOff {
+		dp.Direct = r.repo.Conf().Direct
+	}
+	err = checkDirect(dp.Direct)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := newRequest("", dp.Path, dp.Scope, dp.Bytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := r.adhocTask(req.Context(), &dp)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "deal not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.direct = dp.Direct
+	t.request = request
+
+	started := time.Now()
+	a, err := r.retrieveOnce(req.Context(), t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newDiagnosis(t, a, time.Since(started)))
+}
+
+// adhocTask returns the task of the deal of the param, or of its payload and provider
+func (r *Retrieve) adhocTask(ctx context.Context, dp *DiagnoseParam) (*task, error) {
+	if dp.DealID != 0 {
+		return r.dealTask(ctx, dp.DealID)
+	}
+
+	payload, err := cid.Parse(dp.PayloadCID)
+	if err != nil {
+		return nil, fmt.Errorf("payload cid: %w", err)
+	}
+	provider, err := address.NewFromString(dp.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("provider: %w", err)
+	}
+
+	var dealID int64
+	err = r.repo.DB.QueryRowContext(ctx, `SELECT deal_id FROM Deals WHERE payload_cid=$1 AND provider=$2 ORDER BY deal_id DESC LIMIT 1`, payload.String(), provider.String()).Scan(&dealID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &task{
+		dealID:     dealID,
+		payloadCID: payload,
+		provider:   provider,
+	}, nil
+}
+
+// retrieveOnce runs a single attempt of the task in a manual run
+func (r *Retrieve) retrieveOnce(ctx context.Context, t *task) (a *attempt, err error) {
+	ctx, rn, err := r.startRun(ctx, RunManual)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		r.finishRun(ctx, rn, err)
+	}()
+	t.runID = rn.id
+	rn.tasks.Add(1)
+
+	started := time.Now()
+	a, err = r.attempt(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	return a, r.saveAttempt(ctx, t, 1, started, a)
+}
+
+func newDiagnosis(t *task, a *attempt, took time.Duration) *Diagnosis {
+	d := &Diagnosis{
+		DealID:          t.dealID,
+		PayloadCID:      t.payloadCID.String(),
+		Provider:        t.provider.String(),
+		Request:         t.request.String(),
+		Indexers:        []IndexerDiagnosis{},
+		Candidates:      []CandidateDiagnosis{},
+		IndexerResult:   a.indexerResult,
+		FetchResult:     a.fetchResult,
+		DirectResult:    a.directResult,
+		DirectProtocols: a.directProtocols,
+		Took:            took.String(),
+		Err:             a.errMsg,
+	}
+
+	if a.minerInfo != nil {
+		d.MinerInfo = &MinerInfo{Multiaddrs: []string{}}
+		if a.minerInfo.PeerId != nil {
+			d.MinerInfo.PeerID = a.minerInfo.PeerId.String()
+		}
+		for _, b := range a.minerInfo.Multiaddrs {
+			addr, err := ma.NewMultiaddrBytes(b)
+			if err != nil {
+				d.MinerInfo.Multiaddrs = append(d.MinerInfo.Multiaddrs, fmt.Sprintf("invalid: %s", err))
+				continue
+			}
+			d.MinerInfo.Multiaddrs = append(d.MinerInfo.Multiaddrs, addr.String())
+		}
+	}
+
+	for _, ir := range a.results {
+		id := IndexerDiagnosis{
+			Endpoint: ir.Endpoint,
+			Result:   ir.Result(),
+			Records:  len(ir.Records),
+		}
+		if ir.Err != nil {
+			id.Err = ir.Err.Error()
+		}
+		d.Indexers = append(d.Indexers, id)
+	}
+
+	for _, rec := range a.records {
+		cd := CandidateDiagnosis{
+			PeerID:    rec.Candidate.MinerPeer.ID.String(),
+			Addrs:     []string{},
+			Protocols: []string{},
+		}
+		for _, addr := range rec.Candidate.MinerPeer.Addrs {
+			cd.Addrs = append(cd.Addrs, addr.String())
+		}
+		for _, mc := range rec.Candidate.Metadata.Protocols() {
+			cd.Protocols = append(cd.Protocols, mc.String())
+		}
+		if rec.Err != nil {
+			cd.Err = rec.Err.Error()
+		}
+		d.Candidates = append(d.Candidates, cd)
+	}
+
+	if a.stats != nil {
+		d.Stats = &FetchStats{
+			Provider:     a.stats.StorageProviderId.String(),
+			Size:         a.stats.Size,
+			Blocks:       a.stats.Blocks,
+			Duration:     a.stats.Duration.String(),
+			FirstByte:    a.stats.TimeToFirstByte.String(),
+			AverageSpeed: a.stats.AverageSpeed,
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,82 @@
+package retrieve
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bandwidth paces the bytes stored by all the fetches to rate bytes per second,
+// zero means no limit
+type bandwidth struct {
+	lk   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+func newBandwidth(rate int64) *bandwidth {
+	return &bandwidth{rate: rate}
+}
+
+func (b *bandwidth) setRate(rate int64) {
+	b.lk.Lock()
+	b.rate = rate
+	b.lk.Unlock()
+}
+
+// wait blocks until the bytes stored before are paid off at the rate, then books n bytes
+func (b *bandwidth) wait(ctx context.Context, n int) error {
+	b.lk.Lock()
+	if b.rate <= 0 {
+		b.lk.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(n) * time.Second / time.Duration(b.rate))
+	b.lk.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedStore is the fetch store, its writes are paced by the bandwidth and
+// bounded by the room left in the scratch dir
+type limitedStore struct {
+	fetchStore
+	bandwidth *bandwidth
+	scratch   *scratch
+	used      atomic.Int64
+}
+
+func (s *limitedStore) Put(ctx context.Context, key string, data []byte) error {
+	err := s.bandwidth.wait(ctx, len(data))
+	if err != nil {
+		return err
+	}
+	err = s.scratch.reserve(int64(len(data)))
+	if err != nil {
+		return err
+	}
+	s.used.Add(int64(len(data)))
+	return s.fetchStore.Put(ctx, key, data)
+}
+
+// Close removes the scratch files of the fetch and gives their room back
+func (s *limitedStore) Close() error {
+	s.scratch.release(s.used.Swap(0))
+	return s.fetchStore.Close()
+}
@@ -0,0 +1,65 @@
+package retrieve
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// compareCandidates records every candidate the indexer returned for the payload, with
+// the error of its metadata if invalid, and fetches from each valid one if asked, to see
+// which peers actually serve it
+func (r *Retrieve) compareCandidates(ctx context.Context, t *task, records []Record) error {
+	// a payload without a known deal is not recorded
+	if t.dealID == 0 {
+		return nil
+	}
+	log.Debugw("compare candidates", "dealID", t.dealID, "candidates", len(records), "fetch", t.fetchCandidates)
+
+	for _, rec := range records {
+		rc := rec.Candidate
+		addrs := []string{}
+		for _, a := range rc.MinerPeer.Addrs {
+			addrs = append(addrs, a.String())
+		}
+		protocols := []string{}
+		md := map[string]any{}
+		for _, mc := range rc.Metadata.Protocols() {
+			protocols = append(protocols, mc.String())
+			md[mc.String()] = rc.Metadata.Get(mc)
+		}
+		mdJson, err := json.Marshal(md)
+		if err != nil {
+			return err
+		}
+
+		var mdErr, fetchResult, errMsg any
+		if rec.Err != nil {
+			mdErr = rec.Err.Error()
+		}
+		if t.fetchCandidates && rec.Err == nil {
+			fetchResult = repo.ResultOK
+			errMsg = ""
+			stats, err := r.fetch(ctx, t, false, rc)
+			if err != nil {
+				fetchResult = repo.ResultErr
+				if res := abortCause(ctx, err); res != "" {
+					fetchResult = res
+				}
+				errMsg = err.Error()
+			}
+			log.Debugw("fetch candidate", "dealID", t.dealID, "peer", rc.MinerPeer.ID, "fetch_result", fetchResult, "stats", stats)
+		}
+
+		_, err = r.exec(ctx, `INSERT or REPLACE INTO Candidates (deal_id, peer_id, addrs, protocols, metadata, metadata_err, fetch_result, err_msg, last_update) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, datetime('now'))`,
+			t.dealID, rc.MinerPeer.ID.String(), strings.Join(addrs, ","), strings.Join(protocols, ","), string(mdJson), mdErr, fetchResult, errMsg)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infow("update candidates", "dealID", t.dealID, "candidates", len(records))
+	return nil
+}
@@ -0,0 +1,130 @@
+package retrieve
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage/deferred"
+)
+
+// carWriter returns the writer of the verified CAR of the task, nil if the CAR is thrown away
+func (t *task) carWriter(root cid.Cid) *deferred.DeferredCarWriter {
+	switch {
+	case t.stream != nil:
+		// a response can't be rewound, once a fetch has started it the others are not streamed
+		if t.stream.written {
+			return nil
+		}
+		return deferred.NewDeferredCarWriterForStream(t.stream, []cid.Cid{root}, car.StoreIdentityCIDs(false), car.UseWholeCIDs(false))
+	case t.outputDir != "":
+		return deferred.NewDeferredCarWriterForPath(t.carPath(root), []cid.Cid{root}, car.WriteAsCarV1(true), car.StoreIdentityCIDs(false), car.UseWholeCIDs(false))
+	}
+	return nil
+}
+
+func (t *task) carPath(root cid.Cid) string {
+	return filepath.Join(t.outputDir, fmt.Sprintf("%d-%s.car", t.dealID, root))
+}
+
+// closeCar finishes the CAR of the fetch which ended with err, the file of a failed fetch is removed
+func (t *task) closeCar(cw *deferred.DeferredCarWriter, root cid.Cid, err error) error {
+	cerr := cw.Close()
+	if err == nil {
+		err = cerr
+	}
+	if t.outputDir == "" {
+		return err
+	}
+
+	path := t.carPath(root)
+	if err != nil {
+		rerr := os.Remove(path)
+		if rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			log.Error(rerr)
+		}
+		return err
+	}
+	log.Infow("car written", "dealID", t.dealID, "path", path)
+	return nil
+}
+
+// carResponse streams a CAR as the response, the headers are sent with its first bytes
+type carResponse struct {
+	w       http.ResponseWriter
+	name    string
+	written bool
+}
+
+func (c *carResponse) Write(p []byte) (int, error) {
+	if !c.written {
+		c.w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+		c.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", c.name))
+		c.written = true
+	}
+	return c.w.Write(p)
+}
+
+// CarRetrieve retrieves a deal once and streams the verified CAR back,
+// ?dealID= and optionally path, cid, scope, bytes and direct like a manual retrieval
+func (r *Retrieve) CarRetrieve(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	dealID, err := strconv.ParseInt(q.Get("dealID"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("dealID: %s", err), http.StatusBadRequest)
+		return
+	}
+	direct := q.Get("direct")
+	if direct == directOff {
+		direct = r.repo.Conf().Direct
+	}
+	err = checkDirect(direct)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := newRequest(q.Get("cid"), q.Get("path"), q.Get("scope"), q.Get("bytes"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := r.dealTask(req.Context(), dealID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "deal not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.direct = direct
+	t.request = request
+	t.stream = &carResponse{w: w, name: fmt.Sprintf("%d-%s.car", dealID, request.rootOf(t.payloadCID))}
+
+	a, err := r.retrieveOnce(req.Context(), t)
+	if t.stream.written {
+		if err == nil && a.err != nil {
+			err = a.err
+		}
+		if err != nil {
+			log.Warnw("car stream", "dealID", dealID, "err", err)
+		}
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	msg := a.errMsg
+	if msg == "" {
+		msg = fmt.Sprintf("indexer: %s fetch: %s direct: %s", a.indexerResult, a.fetchResult, a.directResult)
+	}
+	http.Error(w, msg, http.StatusBadGateway)
+}
@@ -0,0 +1,109 @@
+package retrieve
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/gh-efforts/rbot/repo"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// indexer results of a deal
+const (
+	indexerOK              = repo.ResultOK
+	indexerNotIndexed      = repo.IndexerNotIndexed
+	indexerOtherPeers      = repo.IndexerOtherPeers
+	indexerPeerMismatch    = repo.IndexerPeerMismatch
+	indexerInvalidMetadata = repo.IndexerInvalidMetadata
+	indexerNoPeerID        = repo.IndexerNoPeerID
+	// every indexer failed
+	indexerErr = repo.ResultErr
+)
+
+// diagnose explains the indexer result of the payload for the miner, target is the index
+// of the record announced by the miner peer, or -1 if there is none
+func diagnose(mi api.MinerInfo, records []Record, results []*IndexerResult) (string, int, error) {
+	if len(records) == 0 {
+		var errs []error
+		for _, ir := range results {
+			if ir.Err == nil {
+				return indexerNotIndexed, -1, nil
+			}
+			errs = append(errs, ir.Err)
+		}
+		return indexerErr, -1, errors.Join(errs...)
+	}
+
+	if mi.PeerId == nil {
+		return indexerNoPeerID, -1, nil
+	}
+
+	for i, rec := range records {
+		if rec.Candidate.MinerPeer.ID != *mi.PeerId {
+			continue
+		}
+		if rec.Err != nil {
+			return indexerInvalidMetadata, i, rec.Err
+		}
+		return indexerOK, i, nil
+	}
+
+	minerAddrs := []ma.Multiaddr{}
+	for _, b := range mi.Multiaddrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			continue
+		}
+		minerAddrs = append(minerAddrs, a)
+	}
+	for _, rec := range records {
+		if sameHost(rec.Candidate.MinerPeer.Addrs, minerAddrs) {
+			return indexerPeerMismatch, -1, nil
+		}
+	}
+
+	return indexerOtherPeers, -1, nil
+}
+
+// sameHost reports whether any address of a shares the host (ip or dns name) with any of b
+func sameHost(a, b []ma.Multiaddr) bool {
+	for _, x := range a {
+		hx := hostOf(x)
+		if hx == "" {
+			continue
+		}
+		for _, y := range b {
+			if hx == hostOf(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hostOf(a ma.Multiaddr) string {
+	c, _ := ma.SplitFirst(a)
+	if c == nil {
+		return ""
+	}
+	switch c.Protocol().Code {
+	case ma.P_IP4, ma.P_IP6, ma.P_DNS, ma.P_DNS4, ma.P_DNS6:
+		return c.Value()
+	}
+	return ""
+}
+
+func peerIDs(records []Record) string {
+	peers := []string{}
+	seen := map[string]struct{}{}
+	for _, rec := range records {
+		p := rec.Candidate.MinerPeer.ID.String()
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		peers = append(peers, p)
+	}
+	return strings.Join(peers, ",")
+}
@@ -0,0 +1,130 @@
+package retrieve
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	ltypes "github.com/filecoin-project/lassie/pkg/types"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// direct modes, which get the miner address from chain instead of the indexer
+const (
+	directOff = ""
+	// only when the indexer has no usable record of the miner peer
+	directFallback = "fallback"
+	// always, the indexer is not queried
+	directOnly = "only"
+)
+
+// direct results of a deal
+const (
+	directOK          = repo.ResultOK
+	directNoPeerID    = repo.IndexerNoPeerID
+	directNoAddrs     = repo.DirectNoAddrs
+	directNoProtocols = repo.DirectNoProtocols
+	directDialErr     = repo.DirectDialErr
+	directFetchErr    = repo.ResultErr
+)
+
+func checkDirect(mode string) error {
+	switch mode {
+	case directOff, directFallback, directOnly:
+		return nil
+	}
+	return fmt.Errorf("unknown direct mode: %s", mode)
+}
+
+// direct asks the miner for its retrieval transports over libp2p
+// (/fil/retrieval/transports/1.0.0) and fetches from it, bypassing the indexer
+func (r *Retrieve) direct(ctx context.Context, t *task, mi api.MinerInfo, a *attempt) error {
+	result, protocols, err := r.directFetch(ctx, t, mi, a)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	a.directResult = result
+	a.directProtocols = protocols
+	if a.err == nil {
+		a.err = err
+	}
+	if a.errMsg == "" {
+		a.errMsg = errMsg
+	}
+
+	// the results of the indexer are those of this attempt in fallback, of an older one otherwise
+	skipped := ""
+	if t.direct == directOnly {
+		skipped = `indexer_result=NULL, indexer_peers=NULL, fetch_result=NULL, err_msg=NULL, `
+	}
+	_, err = r.exec(ctx, `UPDATE Deals SET `+skipped+`direct_result=$1, direct_protocols=$2, direct_err_msg=$3, last_update=datetime('now') WHERE deal_id=$4`, result, protocols, errMsg, t.dealID)
+	if err != nil {
+		return err
+	}
+
+	log.Infow("update deal", "dealID", t.dealID, "direct_result", result, "direct_protocols", protocols, "direct_err_msg", errMsg)
+	return nil
+}
+
+func (r *Retrieve) directFetch(ctx context.Context, t *task, mi api.MinerInfo, a *attempt) (string, string, error) {
+	if mi.PeerId == nil {
+		return directNoPeerID, "", nil
+	}
+	addrs := []ma.Multiaddr{}
+	for _, b := range mi.Multiaddrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			log.Debugw("invalid miner multiaddr", "provider", t.provider, "err", err)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return directNoAddrs, "", nil
+	}
+
+	provider := ltypes.Provider{
+		Peer: peer.AddrInfo{ID: *mi.PeerId, Addrs: addrs},
+	}
+	source := retriever.NewDirectCandidateSource([]ltypes.Provider{provider}, retriever.WithLibp2pCandidateDiscovery(r.host))
+
+	candidates := []ltypes.RetrievalCandidate{}
+	tctx, cancel := withTimeout(ctx, r.repo.Conf().Timeouts.Indexer)
+	err := source.FindCandidates(tctx, t.payloadCID, func(rc ltypes.RetrievalCandidate) {
+		candidates = append(candidates, rc)
+	})
+	cancel()
+	if err != nil {
+		if res := abortCause(ctx, err); res != "" {
+			return res, "", err
+		}
+		return directDialErr, "", err
+	}
+
+	protocols := []string{}
+	for _, rc := range candidates {
+		for _, mc := range rc.Metadata.Protocols() {
+			protocols = append(protocols, mc.String())
+		}
+	}
+	if len(protocols) == 0 {
+		return directNoProtocols, "", nil
+	}
+
+	stats, err := r.fetch(ctx, t, true, candidates...)
+	a.stats = stats
+	log.Debugw("direct fetch", "dealID", t.dealID, "protocols", protocols, "stats", stats, "err", err)
+	if err != nil {
+		if res := abortCause(ctx, err); res != "" {
+			return res, strings.Join(protocols, ","), err
+		}
+		return directFetchErr, strings.Join(protocols, ","), err
+	}
+
+	return directOK, strings.Join(protocols, ","), nil
+}
@@ -0,0 +1,87 @@
+package retrieve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/lassie/pkg/retriever"
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// results of an attempt aborted by a deadline, a cancellation or a shutdown
+const (
+	resultTimeout     = repo.ResultTimeout
+	resultCanceled    = repo.ResultCanceled
+	resultInterrupted = repo.ResultInterrupted
+)
+
+// errInterrupted cancels the runs still in progress at the shutdown deadline
+var errInterrupted = errors.New("interrupted by shutdown")
+
+// abortResult tells whether err comes from a deadline or a cancellation, empty otherwise
+func abortResult(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, retriever.ErrRetrievalTimedOut):
+		return resultTimeout
+	case errors.Is(err, context.Canceled):
+		return resultCanceled
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return resultTimeout
+	}
+	return ""
+}
+
+// abortCause is abortResult, telling apart the attempts interrupted by a shutdown
+func abortCause(ctx context.Context, err error) string {
+	res := abortResult(err)
+	if res == resultCanceled && errors.Is(context.Cause(ctx), errInterrupted) {
+		return resultInterrupted
+	}
+	return res
+}
+
+// error classes of the retry policy
+const (
+	classTimeout  = "timeout"
+	classCanceled = "canceled"
+	classDial     = "dial"
+	classNotFound = "not-found"
+	classOther    = "other"
+)
+
+// errorClass sorts a retrieval error for the retry policy, lassie reports
+// most of the provider errors as text, so they are matched on the message
+func errorClass(err error) string {
+	switch abortResult(err) {
+	case resultTimeout:
+		return classTimeout
+	case resultCanceled:
+		return classCanceled
+	}
+	if errors.Is(err, retriever.ErrNoCandidates) {
+		return classNotFound
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return classDial
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "timed out"), strings.Contains(msg, "timeout"):
+		return classTimeout
+	case strings.Contains(msg, "dial"), strings.Contains(msg, "connection refused"), strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "no route to host"), strings.Contains(msg, "no good addresses"):
+		return classDial
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "404"):
+		return classNotFound
+	}
+	return classOther
+}
@@ -0,0 +1,164 @@
+package retrieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	ltypes "github.com/filecoin-project/lassie/pkg/types"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+)
+
+const defaultIndexer = "https://cid.contact"
+
+// indexer looks up the payload in several IPNI instances, the candidates
+// come from the first one, in config order, that has the payload indexed
+type indexer struct {
+	endpoints []*url.URL
+	client    *http.Client
+}
+
+// Record is a provider record returned by an indexer, Err is set when
+// its metadata is missing or invalid
+type Record struct {
+	Candidate ltypes.RetrievalCandidate
+	Err       error
+}
+
+// IndexerResult is the answer of one indexer, Err is set when it failed
+type IndexerResult struct {
+	Endpoint string
+	Records  []Record
+	Err      error
+}
+
+// Result is the result of the indexer recorded in IndexerResults
+func (ir *IndexerResult) Result() string {
+	if ir.Err != nil {
+		return repo.ResultErr
+	}
+	if len(ir.Records) == 0 {
+		return repo.IndexerNotFound
+	}
+	return repo.ResultOK
+}
+
+func newIndexer(endpoints []string) (*indexer, error) {
+	if len(endpoints) == 0 {
+		endpoints = []string{defaultIndexer}
+	}
+
+	ix := &indexer{
+		client: &http.Client{Timeout: time.Minute},
+	}
+	for _, e := range endpoints {
+		u, err := url.Parse(e)
+		if err != nil {
+			return nil, fmt.Errorf("indexer endpoint %s: %w", e, err)
+		}
+		ix.endpoints = append(ix.endpoints, u)
+	}
+
+	return ix, nil
+}
+
+// Find queries the indexers concurrently and returns the records of the first one,
+// in config order, which has the payload. The results of all are kept for the diagnosis
+func (ix *indexer) Find(ctx context.Context, c cid.Cid) ([]Record, []*IndexerResult) {
+	results := make([]*IndexerResult, len(ix.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range ix.endpoints {
+		wg.Add(1)
+		go func(i int, e *url.URL) {
+			defer wg.Done()
+			rs, err := ix.findFrom(ctx, e, c)
+			results[i] = &IndexerResult{
+				Endpoint: e.String(),
+				Records:  rs,
+				Err:      err,
+			}
+			log.Debugw("indexer", "endpoint", results[i].Endpoint, "cid", c, "result", results[i].Result(), "records", len(rs), "err", err)
+		}(i, e)
+	}
+	wg.Wait()
+
+	for _, ir := range results {
+		if len(ir.Records) != 0 {
+			return ir.Records, results
+		}
+	}
+	return nil, results
+}
+
+func (ix *indexer) findFrom(ctx context.Context, endpoint *url.URL, c cid.Cid) ([]Record, error) {
+	u := endpoint.JoinPath("multihash", c.Hash().B58String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ix.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("find %s: %s %s", c, resp.Status, string(body))
+	}
+
+	var fr model.FindResponse
+	err = json.NewDecoder(resp.Body).Decode(&fr)
+	if err != nil {
+		return nil, fmt.Errorf("decode find response: %w", err)
+	}
+
+	records := []Record{}
+	for _, mr := range fr.MultihashResults {
+		for _, pr := range mr.ProviderResults {
+			if pr.Provider == nil {
+				continue
+			}
+			rc := ltypes.RetrievalCandidate{
+				MinerPeer: *pr.Provider,
+				RootCid:   c,
+			}
+			md, err := decodeMetadata(pr.Metadata)
+			if err == nil {
+				rc.Metadata = md
+			}
+			records = append(records, Record{Candidate: rc, Err: err})
+		}
+	}
+
+	return records, nil
+}
+
+func decodeMetadata(raw []byte) (metadata.Metadata, error) {
+	if len(raw) == 0 {
+		return metadata.Metadata{}, errors.New("no metadata")
+	}
+	md := metadata.Default.New()
+	if err := md.UnmarshalBinary(raw); err != nil {
+		return metadata.Metadata{}, err
+	}
+	if err := md.Validate(); err != nil {
+		return metadata.Metadata{}, err
+	}
+	return md, nil
+}
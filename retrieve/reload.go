@@ -0,0 +1,74 @@
+package retrieve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/robfig/cron/v3"
+)
+
+// checkConfig checks the settings of the config only known by the retrieval
+func checkConfig(c *repo.Config) error {
+	var errs []error
+	err := checkDirect(c.Direct)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("direct: %w", err))
+	}
+	for i, s := range c.Schedules {
+		_, err := strategyQuery(s.Strategy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedules[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reload applies the new schedules and caps, the runs in progress go on with their tasks
+func (r *Retrieve) reload(old, new *repo.Config) {
+	r.bandwidth.setRate(new.Bandwidth)
+	r.scratch.setLimit(new.ScratchLimit)
+
+	if slices.Equal(old.Schedules, new.Schedules) {
+		return
+	}
+	r.cronLk.Lock()
+	defer r.cronLk.Unlock()
+	// not running yet, Run will use the new schedules
+	if r.cron == nil {
+		return
+	}
+	err := r.schedule(r.cronCtx, new.Schedules)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// schedule replaces the cron runs with the schedules, a run in progress is not stopped
+func (r *Retrieve) schedule(ctx context.Context, schedules []repo.Schedule) error {
+	c := cron.New()
+	for _, s := range schedules {
+		s := s
+		_, err := c.AddFunc(s.Cron, func() {
+			err := r.cronRetrieve(ctx, s)
+			if err != nil {
+				log.Error(err)
+			}
+			//TODO: gc: remove expired deal from db
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.cron != nil {
+		r.cron.Stop()
+	}
+	r.cron = c
+	r.cronCtx = ctx
+	c.Start()
+	log.Infow("schedules", "schedules", schedules)
+	return nil
+}
@@ -0,0 +1,73 @@
+package retrieve
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+// request is what a task fetches, the root block of the payload by default
+type request struct {
+	// root replaces the payload as the root of the fetch if defined, eg: a block inside the DAG
+	root  cid.Cid
+	path  string
+	scope trustlessutils.DagScope
+	bytes *trustlessutils.ByteRange
+}
+
+// newRequest parses the request of a manual retrieval, a byte range implies the entity scope
+func newRequest(root, path, scope, bytes string) (request, error) {
+	req := request{
+		path:  path,
+		scope: trustlessutils.DagScopeBlock,
+	}
+	if root != "" {
+		c, err := cid.Parse(root)
+		if err != nil {
+			return req, fmt.Errorf("cid: %w", err)
+		}
+		req.root = c
+	}
+	if bytes != "" {
+		br, err := trustlessutils.ParseByteRange(bytes)
+		if err != nil {
+			return req, fmt.Errorf("bytes: %w", err)
+		}
+		req.bytes = &br
+		req.scope = trustlessutils.DagScopeEntity
+	}
+	if scope != "" {
+		ds, err := trustlessutils.ParseDagScope(scope)
+		if err != nil {
+			return req, fmt.Errorf("scope: %w", err)
+		}
+		req.scope = ds
+	}
+
+	return req, nil
+}
+
+// rootOf returns the root of the fetch of the payload
+func (req request) rootOf(payload cid.Cid) cid.Cid {
+	if req.root.Defined() {
+		return req.root
+	}
+	return payload
+}
+
+// String describes the request like a trustless gateway path, for the attempt records
+func (req request) String() string {
+	scope := req.scope
+	if scope == "" {
+		scope = trustlessutils.DagScopeBlock
+	}
+	s := fmt.Sprintf("%s?dag-scope=%s", trustlessutils.PathEscape(req.path), scope)
+	if req.root.Defined() {
+		s = "/ipfs/" + req.root.String() + s
+	}
+	if req.bytes != nil && !req.bytes.IsDefault() {
+		s += "&entity-bytes=" + req.bytes.String()
+	}
+	return s
+}
@@ -2,14 +2,20 @@ package retrieve
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-address"
-	"github.com/filecoin-project/lassie/pkg/indexerlookup"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/lassie/pkg/lassie"
+	"github.com/filecoin-project/lassie/pkg/net/host"
 	"github.com/filecoin-project/lassie/pkg/storage"
 	ltypes "github.com/filecoin-project/lassie/pkg/types"
 	"github.com/filecoin-project/lotus/api"
@@ -26,265 +32,696 @@ var log = logging.Logger("retrieve")
 
 type lotusApi interface {
 	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	ChainHead(context.Context) (*types.TipSet, error)
+}
+
+// CandidateSource finds the provider records of a payload, the IPNI indexers of the config
+// by default. Find returns the records of the candidates and the result of each source
+type CandidateSource interface {
+	Find(ctx context.Context, c cid.Cid) ([]Record, []*IndexerResult)
+}
+
+// Fetcher retrieves a request from the providers it lists, lassie by default
+type Fetcher interface {
+	Fetch(ctx context.Context, req ltypes.RetrievalRequest, opts ...ltypes.FetchOption) (*ltypes.RetrievalStats, error)
 }
 
 type Retrieve struct {
 	repo     *repo.Repo
 	lotusApi lotusApi
-	lassie   *lassie.Lassie
-	indexer  *indexerlookup.IndexerCandidateSource
+	host     host.Host
+	lassie   Fetcher
+	indexer  CandidateSource
+	runs     runs
+	// shared by all the fetches
+	bandwidth *bandwidth
+	scratch   *scratch
+	// called with the id and the kind of each finished run, under hooksCtx which is
+	// canceled at the deadline of the shutdown
+	onFinish  []func(context.Context, int64, string)
+	hooksCtx  context.Context
+	stopHooks context.CancelFunc
+
+	// the cron runs, replaced when the schedules are reloaded
+	cronLk  sync.Mutex
+	cron    *cron.Cron
+	cronCtx context.Context
 }
 
 type task struct {
+	runID      int64
 	dealID     int64
 	payloadCID cid.Cid
 	provider   address.Address
+
+	// record every candidate of the payload, and fetch from each of them
+	candidates      bool
+	fetchCandidates bool
+	direct          string
+	request         request
+
+	// the verified CAR of the fetch is written to outputDir or streamed, thrown away if neither
+	outputDir string
+	stream    *carResponse
 }
 
 type ManualParam struct {
 	Providers []string `json:"providers"`
 	Limit     int      `json:"limit"`
 	Parallel  int      `json:"parallel"`
+	// DealIDs, PayloadCIDs and Filter retrieve exactly the deals they select instead
+	// of sampling each provider. Limit caps the deals selected in all, or the deals of
+	// each provider sampled, which defaults to the limit of the config
+	DealIDs     []int64     `json:"dealIDs"`
+	PayloadCIDs []string    `json:"payloadCIDs"`
+	Filter      *DealFilter `json:"filter"`
+	// Strategy selects the deals of each provider, random if empty
+	Strategy string `json:"strategy"`
+	// Candidates records every candidate the indexer returns for the payload,
+	// FetchCandidates also fetches from each of them
+	Candidates      bool `json:"candidates"`
+	FetchCandidates bool `json:"fetchCandidates"`
+	// Direct fetches from the addresses of the miner info, "fallback" or "only",
+	// the config value is used if empty
+	Direct string `json:"direct"`
+	// Path is a UnixFS path inside the payload, CID a block to fetch instead of the payload,
+	// Scope is block, entity or all, Bytes an entity byte range "from:to" of a large file
+	Path  string `json:"path"`
+	CID   string `json:"cid"`
+	Scope string `json:"scope"`
+	Bytes string `json:"bytes"`
+	// Output writes the verified CAR of each fetch to the output dir of the config
+	Output bool `json:"output"`
+}
+
+// Option replaces a default of New, for the tests to run offline
+type Option func(r *Retrieve)
+
+// WithCandidateSource finds the candidates with cs instead of the indexers of the config
+func WithCandidateSource(cs CandidateSource) Option {
+	return func(r *Retrieve) {
+		r.indexer = cs
+	}
 }
 
-func New(ctx context.Context, repo *repo.Repo, lotusApi lotusApi) (*Retrieve, error) {
-	lassie, err := lassie.NewLassie(ctx)
+// WithFetcher fetches with f instead of lassie
+func WithFetcher(f Fetcher) Option {
+	return func(r *Retrieve) {
+		r.lassie = f
+	}
+}
+
+func New(ctx context.Context, repo *repo.Repo, lotusApi lotusApi, opts ...Option) (*Retrieve, error) {
+	err := checkConfig(repo.Conf())
 	if err != nil {
 		return nil, err
 	}
 
-	indexer, err := indexerlookup.NewCandidateSource()
+	host, err := host.InitHost(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	lassie, err := lassie.NewLassie(ctx, lassie.WithHost(host), lassie.WithProviderTimeout(time.Duration(repo.Conf().Timeouts.FirstByte)))
+	if err != nil {
+		return nil, err
+	}
+
+	indexer, err := newIndexer(repo.Conf().Indexers)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, err := newScratch(repo.Conf().ScratchDir, repo.Conf().ScratchLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	hooksCtx, stopHooks := context.WithCancel(context.Background())
 	r := &Retrieve{
 		repo:     repo,
 		lotusApi: lotusApi,
+		host:     host,
 		lassie:   lassie,
 		indexer:  indexer,
+		runs: runs{
+			runs: map[int64]*run{},
+		},
+		bandwidth: newBandwidth(repo.Conf().Bandwidth),
+		scratch:   scratch,
+		hooksCtx:  hooksCtx,
+		stopHooks: stopHooks,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	repo.AddValidator(checkConfig)
+	repo.OnReload(r.reload)
 
 	return r, nil
 }
 
 func (r *Retrieve) Run(ctx context.Context) {
-	c := cron.New()
-	_, err := c.AddFunc("CRON_TZ=Asia/Shanghai 30 01 * * *", func() {
-		err := r.cronRetrieve(ctx)
-		if err != nil {
-			log.Error(err)
-		}
-		//TODO: gc: remove expired deal from db
-	})
+	r.cronLk.Lock()
+	defer r.cronLk.Unlock()
+
+	err := r.schedule(ctx, r.repo.Conf().Schedules)
 	if err != nil {
 		panic(err)
 	}
-	c.Start()
 }
 
-func (r *Retrieve) cronRetrieve(ctx context.Context) error {
-	log.Debug("cron retrieve start")
+func (r *Retrieve) cronRetrieve(ctx context.Context, s repo.Schedule) (err error) {
+	log.Debugw("cron retrieve start", "cron", s.Cron, "strategy", s.Strategy)
 
-	providers, err := r.providers(ctx)
-	if err != nil {
-		return err
+	limit := s.Limit
+	if limit == 0 {
+		limit = r.repo.Conf().Limit
 	}
-	tasks, err := r.tasks(ctx, providers, r.repo.Conf.Limit)
+
+	ctx, rn, err := r.startRun(ctx, RunCron)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		r.finishRun(ctx, rn, err)
+	}()
 
-	err = r.retrieves(ctx, tasks, r.repo.Conf.Parallel)
+	providers, limits, err := r.providers(ctx)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return r.stream(ctx, rn, providers, limit, limits, s.Strategy, r.repo.Conf().Parallel, func(t *task) {
+		t.direct = r.repo.Conf().Direct
+	})
 }
 
+// ManualRetrieve starts a manual run of the JSON param, or of a multipart form with
+// the param as the "param" field and a list of deals as the "deals" file
 func (r *Retrieve) ManualRetrieve(w http.ResponseWriter, req *http.Request) {
 	var mp ManualParam
-	err := json.NewDecoder(req.Body).Decode(&mp)
+	err := decodeManualParam(req, &mp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	err = r.manualRetrieve(req.Context(), &mp)
+	if errors.Is(err, errShuttingDown) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-func (r *Retrieve) manualRetrieve(ctx context.Context, mp *ManualParam) error {
-	log.Debugw("manulRetrieve", "providers", mp.Providers, "limit", mp.Limit, "parallel", mp.Parallel)
+func decodeManualParam(req *http.Request, mp *ManualParam) error {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		return json.NewDecoder(req.Body).Decode(mp)
+	}
 
-	tasks, err := r.tasks(ctx, mp.Providers, mp.Limit)
+	err := req.ParseMultipartForm(32 << 20)
 	if err != nil {
 		return err
 	}
+	if param := req.FormValue("param"); param != "" {
+		err = json.Unmarshal([]byte(param), mp)
+		if err != nil {
+			return err
+		}
+	}
+	f, _, err := req.FormFile("deals")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readDealList(f, mp)
+}
 
-	err = r.retrieves(ctx, tasks, mp.Parallel)
+func (r *Retrieve) manualRetrieve(ctx context.Context, mp *ManualParam) (err error) {
+	if mp.Direct == directOff {
+		mp.Direct = r.repo.Conf().Direct
+	}
+	err = checkDirect(mp.Direct)
 	if err != nil {
 		return err
 	}
-	return nil
+	request, err := newRequest(mp.CID, mp.Path, mp.Scope, mp.Bytes)
+	if err != nil {
+		return err
+	}
+	outputDir := ""
+	if mp.Output {
+		outputDir = r.repo.OutputDir()
+		err = os.MkdirAll(outputDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, rn, err := r.startRun(ctx, RunManual)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		r.finishRun(ctx, rn, err)
+	}()
+
+	log.Debugw("manulRetrieve", "providers", mp.Providers, "limit", mp.Limit, "strategy", mp.Strategy, "parallel", mp.Parallel, "candidates", mp.Candidates, "fetch-candidates", mp.FetchCandidates, "direct", mp.Direct, "request", request, "output", outputDir,
+		"dealIDs", len(mp.DealIDs), "payloadCIDs", len(mp.PayloadCIDs), "filter", mp.Filter)
+
+	setup := func(t *task) {
+		t.candidates = mp.Candidates
+		t.fetchCandidates = mp.FetchCandidates
+		t.direct = mp.Direct
+		t.request = request
+		t.outputDir = outputDir
+	}
+
+	if mp.explicit() {
+		tasks, err := r.selectTasks(ctx, mp)
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			t.runID = rn.id
+			setup(t)
+		}
+		rn.tasks.Add(int64(len(tasks)))
+		return r.retrieves(ctx, tasks, mp.Parallel)
+	}
+
+	limit := mp.Limit
+	if limit == 0 {
+		limit = r.repo.Conf().Limit
+	}
+	return r.stream(ctx, rn, mp.Providers, limit, nil, mp.Strategy, mp.Parallel, setup)
+}
+
+// stream feeds the workers with the tasks of the run as they are selected from the DB,
+// setup applies the options of the run to each task
+func (r *Retrieve) stream(ctx context.Context, rn *run, providers []string, limit int, limits map[string]int, strategy string, parallel int, setup func(*task)) error {
+	s := newScheduler(r.repo.Conf().ProviderParallel)
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer s.close()
+		errCh <- r.tasks(ctx, providers, limit, limits, strategy, func(t *task) {
+			t.runID = rn.id
+			setup(t)
+			rn.tasks.Add(1)
+			s.push(t)
+		})
+	}()
+
+	err := r.work(ctx, s, parallel)
+	if perr := <-errCh; perr != nil {
+		return perr
+	}
+	return err
 }
 
 func (r *Retrieve) retrieves(ctx context.Context, tasks []*task, parallel int) error {
-	var wg sync.WaitGroup
-	throttle := make(chan struct{}, parallel)
+	s := newScheduler(r.repo.Conf().ProviderParallel)
 	for _, t := range tasks {
-		wg.Add(1)
-		throttle <- struct{}{}
+		s.push(t)
+	}
+	s.close()
 
-		go func(t *task) {
-			defer wg.Done()
-			defer func() {
-				<-throttle
-			}()
+	return r.work(ctx, s, parallel)
+}
+
+// work runs parallel workers on the tasks of the scheduler until it is drained or ctx is done
+func (r *Retrieve) work(ctx context.Context, s *scheduler, parallel int) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	stop := s.wake(ctx)
+	defer stop()
 
-			err := r.retrieve(ctx, t)
-			if err != nil {
-				log.Error(err)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				t, ok := s.pop(ctx)
+				if !ok {
+					return
+				}
+				err := r.retrieve(ctx, t)
+				if err != nil {
+					log.Error(err)
+				}
+				s.done(t)
 			}
-		}(t)
+		}()
 	}
 	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Warnw("retrieves aborted", "err", context.Cause(ctx))
+		return context.Cause(ctx)
+	}
 	return nil
 }
 
 func (r *Retrieve) retrieve(ctx context.Context, t *task) error {
+	for n := 1; ; n++ {
+		started := time.Now()
+		a, err := r.attempt(ctx, t)
+		if err != nil {
+			return err
+		}
+		err = r.saveAttempt(ctx, t, n, started, a)
+		if err != nil {
+			return err
+		}
+
+		delay, ok := r.retryDelay(n, a.err)
+		if !ok {
+			return nil
+		}
+		log.Infow("retry", "dealID", t.dealID, "attempt", n, "delay", delay, "err", a.err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// attempt retrieves the task once and records the outcome on the deal
+func (r *Retrieve) attempt(ctx context.Context, t *task) (*attempt, error) {
 	log.Debugw("retrieving", "dealID", t.dealID)
+	a := &attempt{}
 
-	mi, err := r.lotusApi.StateMinerInfo(ctx, t.provider, types.EmptyTSK)
+	tctx, cancel := withTimeout(ctx, r.repo.Conf().Timeouts.MinerInfo)
+	mi, err := r.lotusApi.StateMinerInfo(tctx, t.provider, types.EmptyTSK)
+	cancel()
 	if err != nil {
-		return err
+		return a, r.fail(ctx, t, "miner info", err, a)
 	}
+	a.minerInfo = &mi
 
-	target := ltypes.RetrievalCandidate{}
-	err = r.indexer.FindCandidates(ctx, t.payloadCID, func(rc ltypes.RetrievalCandidate) {
-		if rc.MinerPeer.ID == *mi.PeerId {
-			target = rc
-		}
-	})
-	if err != nil {
-		return err
+	if t.direct == directOnly {
+		return a, r.direct(ctx, t, mi, a)
 	}
 
-	log.Debugw("FindCandidates", "dealID", t.dealID, "target", target)
+	tctx, cancel = withTimeout(ctx, r.repo.Conf().Timeouts.Indexer)
+	records, results := r.indexer.Find(tctx, t.payloadCID)
+	cancel()
+	a.records = records
+	a.results = results
+	err = r.saveIndexerResults(ctx, t.dealID, results)
+	if err != nil {
+		return nil, err
+	}
 
-	if !target.RootCid.Equals(t.payloadCID) || target.MinerPeer.ID != *mi.PeerId {
-		_, err := r.repo.DB.ExecContext(ctx, `UPDATE Deals SET indexer_result=$1, last_update=datetime('now') WHERE deal_id=$2`, "NOT_FOUND", t.dealID)
+	if t.candidates || t.fetchCandidates {
+		err = r.compareCandidates(ctx, t, records)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		log.Infow("update deal", "dealID", t.dealID, "index_result", "NOT_FOUND")
-		return nil
-	}
-
-	err = target.Metadata.Validate()
-	if err != nil {
-		return err
 	}
 
-	store := storage.NewDeferredStorageCar(os.TempDir(), target.RootCid)
-	defer store.Close()
-	req, err := ltypes.NewRequestForPath(store, target.RootCid, "", trustlessutils.DagScopeBlock, nil)
-	if err != nil {
-		return err
+	indexerResult, i, err := diagnose(mi, records, results)
+	if indexerResult == indexerErr {
+		if res := abortCause(ctx, err); res != "" {
+			indexerResult = res
+		}
 	}
+	peers := peerIDs(records)
+	log.Debugw("FindCandidates", "dealID", t.dealID, "indexer_result", indexerResult, "peers", peers)
+	a.indexerResult = indexerResult
 
-	protocols := []metadata.Protocol{}
-	for _, mc := range target.Metadata.Protocols() {
-		protocols = append(protocols, target.Metadata.Get(mc))
+	if indexerResult != indexerOK {
+		if err != nil {
+			a.errMsg = err.Error()
+			// only the failures of the indexers themselves may be retried
+			if indexerResult != indexerInvalidMetadata {
+				a.err = err
+			}
+		}
+		_, err := r.exec(ctx, `UPDATE Deals SET indexer_result=$1, indexer_peers=$2, fetch_result=NULL, err_msg=$3, direct_result=NULL, direct_protocols=NULL, direct_err_msg=NULL, last_update=datetime('now') WHERE deal_id=$4`, indexerResult, peers, a.errMsg, t.dealID)
+		if err != nil {
+			return nil, err
+		}
+		log.Infow("update deal", "dealID", t.dealID, "index_result", indexerResult, "peers", peers)
 
+		if t.direct == directFallback {
+			return a, r.direct(ctx, t, mi, a)
+		}
+		return a, nil
 	}
-	req.Providers = []ltypes.Provider{
-		{
-			Peer:      target.MinerPeer,
-			Protocols: protocols,
-		},
-	}
+	target := records[i].Candidate
 
-	fetch_result := "OK"
+	fetch_result := repo.ResultOK
 	err_msg := ""
-	stats, err := r.lassie.Fetch(ctx, req)
+	stats, err := r.fetch(ctx, t, true, target)
 	if err != nil {
 		log.Error(err)
-		fetch_result = "ERR"
+		fetch_result = repo.ResultErr
+		if res := abortCause(ctx, err); res != "" {
+			fetch_result = res
+		}
 		err_msg = err.Error()
 	}
+	a.fetchResult = fetch_result
+	a.errMsg = err_msg
+	a.err = err
+	a.stats = stats
 
 	log.Debugw("fetch", "dealID", t.dealID, "fetch_result", fetch_result, "stats", stats)
 
-	_, err = r.repo.DB.ExecContext(ctx, `UPDATE Deals SET indexer_result=$1, fetch_result=$2, err_msg=$3, last_update=datetime('now') WHERE deal_id=$4`, "OK", fetch_result, err_msg, t.dealID)
+	_, err = r.exec(ctx, `UPDATE Deals SET indexer_result=$1, indexer_peers=$2, fetch_result=$3, err_msg=$4, direct_result=NULL, direct_protocols=NULL, direct_err_msg=NULL, last_update=datetime('now') WHERE deal_id=$5`, indexerOK, peers, fetch_result, err_msg, t.dealID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	log.Infow("update deal", "dealID", t.dealID, "fetch_result", fetch_result, "err_msg", err_msg)
+	return a, nil
+}
+
+// fail records the attempt of the task which failed at the phase
+func (r *Retrieve) fail(ctx context.Context, t *task, phase string, err error, a *attempt) error {
+	res := abortCause(ctx, err)
+	if res == "" {
+		res = repo.ResultErr
+	}
+
+	a.fetchResult = res
+	a.errMsg = fmt.Sprintf("%s: %s", phase, err)
+	a.err = err
+	_, dbErr := r.exec(ctx, `UPDATE Deals SET indexer_result=NULL, indexer_peers=NULL, fetch_result=$1, err_msg=$2, direct_result=NULL, direct_protocols=NULL, direct_err_msg=NULL, last_update=datetime('now') WHERE deal_id=$3`, a.fetchResult, a.errMsg, t.dealID)
+	if dbErr != nil {
+		return dbErr
+	}
+	log.Infow("update deal", "dealID", t.dealID, "fetch_result", a.fetchResult, "err_msg", a.errMsg)
+	return nil
+}
+
+// exec writes to the DB even if ctx is done, so the results of aborted attempts are kept
+func (r *Retrieve) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.repo.DB.ExecContext(context.WithoutCancel(ctx), query, args...)
+}
+
+func (r *Retrieve) saveIndexerResults(ctx context.Context, dealID int64, results []*IndexerResult) error {
+	// a payload without a known deal is not recorded
+	if dealID == 0 {
+		return nil
+	}
+	for _, ir := range results {
+		errMsg := ""
+		if ir.Err != nil {
+			errMsg = ir.Err.Error()
+		}
+		_, err := r.exec(ctx, `INSERT or REPLACE INTO IndexerResults (deal_id, indexer, result, records, err_msg, last_update) VALUES ($1, $2, $3, $4, $5, datetime('now'))`,
+			dealID, ir.Endpoint, ir.Result(), len(ir.Records), errMsg)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (r *Retrieve) tasks(ctx context.Context, providers []string, limit int) ([]*task, error) {
-	tasks := []*task{}
+// fetch retrieves the request of the task, the root block of the payload by default,
+// from the candidate peers only, output writes the verified CAR if the task asks for it
+func (r *Retrieve) fetch(ctx context.Context, t *task, output bool, candidates ...ltypes.RetrievalCandidate) (stats *ltypes.RetrievalStats, err error) {
+	if len(candidates) == 0 {
+		return nil, errors.New("no candidates")
+	}
+	ctx, cancel := withTimeout(ctx, r.repo.Conf().Timeouts.Fetch)
+	defer cancel()
+
+	root := t.request.rootOf(t.payloadCID)
+	scope := t.request.scope
+	if scope == "" {
+		scope = trustlessutils.DagScopeBlock
+	}
+	tempStore := storage.NewDeferredStorageCar(r.scratch.dir, root)
+	var carStore fetchStore = tempStore
+	cw := t.carWriter(root)
+	if output && cw != nil {
+		// the blocks are written to the CAR in the order of the verified traversal
+		carStore = storage.NewCachingTempStore(cw.BlockWriteOpener(), tempStore)
+		defer func() {
+			err = t.closeCar(cw, root, err)
+		}()
+	}
+	store := &limitedStore{
+		fetchStore: carStore,
+		bandwidth:  r.bandwidth,
+		scratch:    r.scratch,
+	}
+	defer store.Close()
+	req, err := ltypes.NewRequestForPath(store, root, t.request.path, scope, t.request.bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rc := range candidates {
+		protocols := []metadata.Protocol{}
+		for _, mc := range rc.Metadata.Protocols() {
+			protocols = append(protocols, rc.Metadata.Get(mc))
+
+		}
+		req.Providers = append(req.Providers, ltypes.Provider{
+			Peer:      rc.MinerPeer,
+			Protocols: protocols,
+		})
+	}
+
+	return r.lassie.Fetch(ctx, req)
+}
+
+// tasks selects the deals of each provider by the strategy and hands them to
+// push one by one, the rows of a provider are closed before the next is queried.
+// limits overrides limit per provider
+func (r *Retrieve) tasks(ctx context.Context, providers []string, limit int, limits map[string]int, strategy string, push func(*task)) error {
+	query, err := strategyQuery(strategy)
+	if err != nil {
+		return err
+	}
+	head, err := r.lotusApi.ChainHead(ctx)
+	if err != nil {
+		return err
+	}
+
+	count := 0
 	for _, p := range providers {
-		rows, err := r.repo.DB.QueryContext(ctx, `SELECT deal_id,payload_cid,provider FROM Deals WHERE provider=$1 ORDER BY RANDOM() LIMIT $2`, p, limit)
+		l := limit
+		if pl, ok := limits[p]; ok {
+			l = pl
+		}
+		n, err := r.providerTasks(ctx, query, p, head.Height(), l, push)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		defer rows.Close()
+		count += n
+	}
 
-		for rows.Next() {
-			var dealID int64
-			var payloadCID, provider string
+	log.Debugw("tasks", "providers", providers, "limit", limit, "strategy", strategy, "counts", count)
+	return nil
+}
 
-			err := rows.Scan(&dealID, &payloadCID, &provider)
-			if err != nil {
-				return nil, err
-			}
+func (r *Retrieve) providerTasks(ctx context.Context, query string, provider string, height abi.ChainEpoch, limit int, push func(*task)) (int, error) {
+	rows, err := r.repo.DB.QueryContext(ctx, query, provider, height, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
 
-			payload, err := cid.Parse(payloadCID)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			addr, err := address.NewFromString(provider)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			tasks = append(tasks, &task{
-				dealID:     dealID,
-				payloadCID: payload,
-				provider:   addr,
-			})
+	n := 0
+	for rows.Next() {
+		var dealID int64
+		var payloadCID, provider string
+
+		err := rows.Scan(&dealID, &payloadCID, &provider)
+		if err != nil {
+			return n, err
+		}
+
+		payload, err := cid.Parse(payloadCID)
+		if err != nil {
+			log.Error(err)
+			continue
 		}
+		addr, err := address.NewFromString(provider)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		push(&task{
+			dealID:     dealID,
+			payloadCID: payload,
+			provider:   addr,
+		})
+		n++
 	}
 
-	log.Debugw("tasks", "providers", providers, "limit", limit, "counts", len(tasks))
-	return tasks, nil
+	return n, rows.Err()
 }
 
-func (r *Retrieve) providers(ctx context.Context) ([]string, error) {
-	rows, err := r.repo.DB.QueryContext(ctx, `SELECT DISTINCT provider FROM Deals`)
+// dealTask returns the task of the deal, sql.ErrNoRows if the deal is unknown
+func (r *Retrieve) dealTask(ctx context.Context, dealID int64) (*task, error) {
+	var payloadCID, provider string
+	err := r.repo.DB.QueryRowContext(ctx, `SELECT payload_cid, provider FROM Deals WHERE deal_id=$1`, dealID).Scan(&payloadCID, &provider)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := cid.Parse(payloadCID)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := address.NewFromString(provider)
 	if err != nil {
 		return nil, err
 	}
+	return &task{
+		dealID:     dealID,
+		payloadCID: payload,
+		provider:   addr,
+	}, nil
+}
+
+// providers returns the enabled providers which have deals, and their custom limits,
+// every provider of the deals if none was ever added, as the chain events filter does
+func (r *Retrieve) providers(ctx context.Context) ([]string, map[string]int, error) {
+	all, err := r.repo.TrackAll(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := `SELECT DISTINCT d.provider, p.deal_limit FROM Deals d JOIN Providers p ON d.provider = p.provider WHERE p.enabled AND NOT p.removed`
+	if all {
+		query = `SELECT DISTINCT provider, 0 FROM Deals`
+	}
+	rows, err := r.repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
 
 	providers := []string{}
+	limits := map[string]int{}
 	for rows.Next() {
 		var provider string
-		err := rows.Scan(&provider)
+		var limit int
+		err := rows.Scan(&provider, &limit)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		providers = append(providers, provider)
+		if limit > 0 {
+			limits[provider] = limit
+		}
 	}
 
-	log.Debugf("provider: %s", providers)
-	return providers, nil
+	log.Debugw("providers", "providers", providers, "limits", limits)
+	return providers, limits, rows.Err()
 }
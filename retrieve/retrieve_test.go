@@ -0,0 +1,280 @@
+package retrieve
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	ltypes "github.com/filecoin-project/lassie/pkg/types"
+	"github.com/gh-efforts/rbot/internal/fake"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// candidates always finds the payload at the peer
+type candidates struct {
+	peer peer.AddrInfo
+}
+
+func (c *candidates) Find(ctx context.Context, payload cid.Cid) ([]Record, []*IndexerResult) {
+	rec := Record{Candidate: ltypes.RetrievalCandidate{
+		MinerPeer: c.peer,
+		RootCid:   payload,
+		Metadata:  metadata.Default.New(&metadata.IpfsGatewayHttp{}),
+	}}
+	return []Record{rec}, []*IndexerResult{{Endpoint: "fake", Records: []Record{rec}}}
+}
+
+// fetches answers the fetches in order with errs, the last one repeated,
+// errBlock blocks until the fetch is canceled
+type fetches struct {
+	lk      sync.Mutex
+	errs    []error
+	n       int
+	started chan struct{}
+}
+
+var errBlock = errors.New("block")
+
+func (f *fetches) Fetch(ctx context.Context, req ltypes.RetrievalRequest, opts ...ltypes.FetchOption) (*ltypes.RetrievalStats, error) {
+	f.lk.Lock()
+	err := f.errs[min(f.n, len(f.errs)-1)]
+	f.n++
+	f.lk.Unlock()
+
+	if err == errBlock {
+		close(f.started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ltypes.RetrievalStats{RootCid: req.Root, Size: 1}, nil
+}
+
+func newTestRetrieve(t *testing.T, f *fetches) *Retrieve {
+	t.Helper()
+	p, err := fake.NewProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	miner, err := address.NewIDAddress(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lotus := fake.NewLotus(10000)
+	lotus.SetMinerInfo(miner, p.MinerInfo())
+
+	dir := t.TempDir()
+	err = repo.Init(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := repo.New(dir, map[string]string{
+		"lotus":            "eyJhbGciOiJIUzI1NiJ9.eyJBbGxvdyI6WyJyZWFkIl19.c2ln:/ip4/127.0.0.1/tcp/1234/http",
+		"retry.attempts":   "3",
+		"retry.backoff":    "1ms",
+		"retry.maxBackoff": "1ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	_, err = r.DB.Exec(`INSERT INTO Deals (deal_id, payload_cid, client, provider, start_epoch, end_epoch, sector_start_epoch) VALUES (1, $1, 'f01001', $2, 9900, 100000, 9990)`,
+		fake.RawCid([]byte("payload")).String(), miner.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := New(context.Background(), r, lotus, WithCandidateSource(&candidates{peer: p.AddrInfo()}), WithFetcher(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+// results returns the fetch results of the attempts of deal 1 in order
+func results(t *testing.T, rt *Retrieve) []string {
+	t.Helper()
+	rows, err := rt.repo.DB.Query(`SELECT fetch_result FROM Attempts WHERE deal_id=1 ORDER BY attempt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	res := []string{}
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func TestRetryTimeout(t *testing.T) {
+	f := &fetches{errs: []error{context.DeadlineExceeded, context.DeadlineExceeded, nil}}
+	rt := newTestRetrieve(t, f)
+	defer rt.Shutdown(context.Background())
+
+	err := rt.manualRetrieve(context.Background(), &ManualParam{DealIDs: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := results(t, rt)
+	want := []string{resultTimeout, resultTimeout, repo.ResultOK}
+	if len(got) != len(want) {
+		t.Fatalf("attempts: got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("attempts: got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestShutdownInterrupts(t *testing.T) {
+	f := &fetches{errs: []error{errBlock}, started: make(chan struct{})}
+	rt := newTestRetrieve(t, f)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rt.manualRetrieve(context.Background(), &ManualParam{DealIDs: []int64{1}})
+	}()
+	<-f.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rt.Shutdown(ctx)
+	<-done
+
+	got := results(t, rt)
+	if len(got) != 1 || got[0] != resultInterrupted {
+		t.Fatalf("attempts: got %v, want [%s]", got, resultInterrupted)
+	}
+	var run string
+	err := rt.repo.DB.QueryRow(`SELECT result FROM Runs`).Scan(&run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run != resultInterrupted {
+		t.Fatalf("run: got %s, want %s", run, resultInterrupted)
+	}
+	err = rt.manualRetrieve(context.Background(), &ManualParam{DealIDs: []int64{1}})
+	if err != errShuttingDown {
+		t.Fatalf("run after shutdown: got %v, want %v", err, errShuttingDown)
+	}
+}
+
+func TestDisabledProvidersNotRetrieved(t *testing.T) {
+	rt := newTestRetrieve(t, &fetches{errs: []error{nil}})
+	defer rt.Shutdown(context.Background())
+	ctx := context.Background()
+
+	// with no provider added, every provider of the deals is retrieved
+	providers, _, err := rt.providers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 1 {
+		t.Fatalf("providers: got %v, want the one of the deal", providers)
+	}
+
+	enabled := false
+	err = rt.repo.AddProvider(ctx, &repo.ProviderUpdate{Provider: "f01000", Enabled: &enabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers, limits, err := rt.providers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := 0
+	err = rt.tasks(ctx, providers, 10, limits, "", func(*task) { tasks++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 0 || tasks != 0 {
+		t.Fatalf("got providers %v and %d tasks, want none", providers, tasks)
+	}
+}
+
+// invalid adds a record of another peer with invalid metadata to the candidates
+type invalid struct {
+	candidates
+}
+
+func (c *invalid) Find(ctx context.Context, payload cid.Cid) ([]Record, []*IndexerResult) {
+	records, results := c.candidates.Find(ctx, payload)
+	records = append(records, Record{
+		Candidate: ltypes.RetrievalCandidate{MinerPeer: peer.AddrInfo{ID: peer.ID("other")}, RootCid: payload},
+		Err:       errors.New("no metadata"),
+	})
+	return records, results
+}
+
+func TestInvalidCandidatesRecorded(t *testing.T) {
+	f := &fetches{errs: []error{nil}}
+	rt := newTestRetrieve(t, f)
+	defer rt.Shutdown(context.Background())
+	rt.indexer = &invalid{*rt.indexer.(*candidates)}
+
+	err := rt.manualRetrieve(context.Background(), &ManualParam{DealIDs: []int64{1}, FetchCandidates: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := rt.repo.DB.Query(`SELECT COALESCE(metadata_err, ''), COALESCE(fetch_result, '') FROM Candidates WHERE deal_id=1 ORDER BY metadata_err IS NULL`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := []string{}
+	for rows.Next() {
+		var mdErr, fetchResult string
+		err = rows.Scan(&mdErr, &fetchResult)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, mdErr+"/"+fetchResult)
+	}
+	want := []string{"no metadata/", "/" + repo.ResultOK}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("candidates: got %v, want %v", got, want)
+	}
+	// the deal and the valid candidate only
+	if f.n != 2 {
+		t.Fatalf("fetches: got %d, want 2", f.n)
+	}
+}
+
+func TestShutdownCancelsHooks(t *testing.T) {
+	rt := newTestRetrieve(t, &fetches{errs: []error{nil}})
+	called := make(chan struct{})
+	rt.OnFinish(func(ctx context.Context, runID int64, kind string) {
+		// a webhook which never answers
+		close(called)
+		<-ctx.Done()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rt.manualRetrieve(context.Background(), &ManualParam{DealIDs: []int64{1}})
+	}()
+	<-called
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rt.Shutdown(ctx)
+	<-done
+}
@@ -0,0 +1,72 @@
+package retrieve
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	ltypes "github.com/filecoin-project/lassie/pkg/types"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// attempt is the outcome of one retrieval of a task
+type attempt struct {
+	indexerResult string
+	fetchResult   string
+	directResult  string
+	errMsg        string
+	// err decides whether the attempt is retried
+	err error
+
+	// details of the phases, for the diagnosis of ad-hoc retrievals
+	minerInfo       *api.MinerInfo
+	results         []*IndexerResult
+	records         []Record
+	directProtocols string
+	stats           *ltypes.RetrievalStats
+}
+
+func (r *Retrieve) saveAttempt(ctx context.Context, t *task, n int, started time.Time, a *attempt) error {
+	// a payload without a known deal is not recorded
+	if t.dealID == 0 {
+		return nil
+	}
+	class := ""
+	if a.err != nil {
+		class = errorClass(a.err)
+	}
+	_, err := r.exec(ctx, `INSERT INTO Attempts (run_id, deal_id, provider, attempt, request, indexer_result, fetch_result, direct_result, err_class, err_msg, started_at, duration_ms) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		t.runID, t.dealID, t.provider.String(), n, t.request.String(), a.indexerResult, a.fetchResult, a.directResult, class, a.errMsg, started.UTC().Format(time.DateTime), time.Since(started).Milliseconds())
+	return err
+}
+
+// retryDelay returns the backoff before the next attempt, if the error of
+// the attempt n is worth retrying
+func (r *Retrieve) retryDelay(n int, err error) (time.Duration, bool) {
+	policy := r.repo.Conf().Retry
+	if err == nil || n >= policy.Attempts {
+		return 0, false
+	}
+	// a payload not found or a canceled run is never retried
+	class := errorClass(err)
+	if class == classNotFound || class == classCanceled || !slices.Contains(policy.Classes, class) {
+		return 0, false
+	}
+
+	return backoff(policy, n), true
+}
+
+// backoff returns the backoff after the attempt n, doubled from Backoff at each
+// attempt, up to MaxBackoff if set
+func backoff(policy repo.Retry, n int) time.Duration {
+	delay := time.Duration(policy.Backoff)
+	limit := time.Duration(policy.MaxBackoff)
+	for i := 1; i < n && (limit <= 0 || delay < limit); i++ {
+		delay *= 2
+	}
+	if limit > 0 {
+		delay = min(delay, limit)
+	}
+	return delay
+}
@@ -0,0 +1,31 @@
+package retrieve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		name    string
+		backoff time.Duration
+		max     time.Duration
+		n       int
+		want    time.Duration
+	}{
+		{"first", time.Second, time.Minute, 1, time.Second},
+		{"doubled", time.Second, time.Minute, 3, 4 * time.Second},
+		{"capped", time.Second, 5 * time.Second, 4, 5 * time.Second},
+		{"no max", time.Second, 0, 4, 8 * time.Second},
+		{"backoff over max", time.Minute, time.Second, 1, time.Second},
+		{"backoff over max, later", time.Minute, time.Second, 3, time.Second},
+	}
+	for _, c := range cases {
+		got := backoff(repo.Retry{Backoff: repo.Duration(c.backoff), MaxBackoff: repo.Duration(c.max)}, c.n)
+		if got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package retrieve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// kinds of run, only the cron ones sample the deals of every provider evenly
+const (
+	RunCron   = "cron"
+	RunManual = "manual"
+)
+
+var errRunCanceled = errors.New("run canceled")
+
+type run struct {
+	id      int64
+	kind    string
+	started time.Time
+	tasks   atomic.Int64
+	cancel  context.CancelCauseFunc
+}
+
+type runs struct {
+	lk   sync.Mutex
+	runs map[int64]*run
+	// set by Shutdown, no run starts afterwards
+	closing bool
+	// set at the shutdown deadline, the runs starting meanwhile are interrupted at once
+	interrupted bool
+	// the runs until they are finished and recorded
+	active sync.WaitGroup
+}
+
+type RunInfo struct {
+	ID      int64     `json:"id"`
+	Kind    string    `json:"kind"`
+	Started time.Time `json:"started"`
+	Tasks   int64     `json:"tasks"`
+}
+
+var errShuttingDown = errors.New("shutting down")
+
+// startRun records a new run, its context is canceled by the cancel API, the run timeout
+// or the shutdown deadline
+func (r *Retrieve) startRun(ctx context.Context, kind string) (context.Context, *run, error) {
+	r.runs.lk.Lock()
+	if r.runs.closing {
+		r.runs.lk.Unlock()
+		return nil, nil, errShuttingDown
+	}
+	r.runs.active.Add(1)
+	r.runs.lk.Unlock()
+
+	ret, err := r.repo.DB.ExecContext(ctx, `INSERT INTO Runs (kind, started_at) VALUES ($1, datetime('now'))`, kind)
+	if err != nil {
+		r.runs.active.Done()
+		return nil, nil, err
+	}
+	id, err := ret.LastInsertId()
+	if err != nil {
+		r.runs.active.Done()
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	if timeout := time.Duration(r.repo.Conf().Timeouts.Run); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancelCause := cancel
+		cancel = func(cause error) {
+			cancelCause(cause)
+			cancelTimeout()
+		}
+	}
+
+	rn := &run{
+		id:      id,
+		kind:    kind,
+		started: time.Now(),
+		cancel:  cancel,
+	}
+	r.runs.lk.Lock()
+	r.runs.runs[id] = rn
+	if r.runs.interrupted {
+		rn.cancel(errInterrupted)
+	}
+	r.runs.lk.Unlock()
+
+	log.Infow("run start", "id", id, "kind", kind)
+	return ctx, rn, nil
+}
+
+func (r *Retrieve) finishRun(ctx context.Context, rn *run, err error) {
+	defer r.runs.active.Done()
+
+	r.runs.lk.Lock()
+	delete(r.runs.runs, rn.id)
+	r.runs.lk.Unlock()
+
+	result := repo.ResultOK
+	errMsg := ""
+	if errors.Is(context.Cause(ctx), errRunCanceled) {
+		result = resultCanceled
+	} else if ctx.Err() != nil {
+		result = abortCause(ctx, ctx.Err())
+	} else if err != nil {
+		result = repo.ResultErr
+		errMsg = err.Error()
+	}
+	rn.cancel(nil)
+
+	_, dbErr := r.exec(ctx, `UPDATE Runs SET tasks=$1, result=$2, err_msg=$3, finished_at=datetime('now') WHERE run_id=$4`, rn.tasks.Load(), result, errMsg, rn.id)
+	if dbErr != nil {
+		log.Error(dbErr)
+	}
+	log.Infow("run finish", "id", rn.id, "kind", rn.kind, "tasks", rn.tasks.Load(), "result", result, "took", time.Since(rn.started), "err", err)
+
+	// the attempts cut by the shutdown say nothing of the providers
+	if result == resultInterrupted {
+		return
+	}
+	for _, f := range r.onFinish {
+		f(r.hooksCtx, rn.id, rn.kind)
+	}
+}
+
+// OnFinish registers f to be called with the id and the kind of each run once it is finished and recorded
+func (r *Retrieve) OnFinish(f func(ctx context.Context, runID int64, kind string)) {
+	r.onFinish = append(r.onFinish, f)
+}
+
+// withTimeout bounds ctx with d, zero means no bound
+func withTimeout(ctx context.Context, d repo.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(d))
+}
+
+// Shutdown stops the cron runs and refuses new runs, then waits for the runs in progress
+// and their hooks until ctx is done, interrupts the ones left, cancels the hooks and waits
+// for the attempts to be recorded
+func (r *Retrieve) Shutdown(ctx context.Context) {
+	r.cronLk.Lock()
+	if r.cron != nil {
+		r.cron.Stop()
+		// reloads no longer reschedule
+		r.cron = nil
+	}
+	r.cronLk.Unlock()
+
+	r.runs.lk.Lock()
+	r.runs.closing = true
+	n := len(r.runs.runs)
+	r.runs.lk.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.runs.active.Wait()
+		close(done)
+	}()
+
+	if n > 0 {
+		log.Infow("waiting for the runs in progress", "runs", n)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		r.runs.lk.Lock()
+		r.runs.interrupted = true
+		for _, rn := range r.runs.runs {
+			log.Warnw("interrupting run", "id", rn.id, "kind", rn.kind, "tasks", rn.tasks.Load())
+			rn.cancel(errInterrupted)
+		}
+		r.runs.lk.Unlock()
+		r.stopHooks()
+		<-done
+	}
+
+	err := r.host.Close()
+	if err != nil {
+		log.Warnw("close host", "err", err)
+	}
+	log.Info("retrieve stopped")
+}
+
+// Running returns the runs in progress
+func (r *Retrieve) Running() []RunInfo {
+	r.runs.lk.Lock()
+	defer r.runs.lk.Unlock()
+
+	infos := []RunInfo{}
+	for _, rn := range r.runs.runs {
+		infos = append(infos, RunInfo{
+			ID:      rn.id,
+			Kind:    rn.kind,
+			Started: rn.started,
+			Tasks:   rn.tasks.Load(),
+		})
+	}
+	return infos
+}
+
+// Runs lists the runs in progress
+func (r *Retrieve) Runs(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Running())
+}
+
+// Cancel aborts the run of the id, or every run in progress if id is empty
+func (r *Retrieve) Cancel(w http.ResponseWriter, req *http.Request) {
+	var id int64
+	if s := req.URL.Query().Get("id"); s != "" {
+		var err error
+		id, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	r.runs.lk.Lock()
+	defer r.runs.lk.Unlock()
+
+	if id != 0 {
+		rn, ok := r.runs.runs[id]
+		if !ok {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		rn.cancel(errRunCanceled)
+		log.Infow("run canceled", "id", id)
+		return
+	}
+	for _, rn := range r.runs.runs {
+		rn.cancel(errRunCanceled)
+		log.Infow("run canceled", "id", rn.id)
+	}
+}
@@ -0,0 +1,116 @@
+package retrieve
+
+import (
+	"context"
+	"sync"
+)
+
+// scheduler hands out the tasks round robin across providers, keeping at most
+// perProvider tasks of a provider in flight, zero means no cap
+type scheduler struct {
+	lk          sync.Mutex
+	cond        *sync.Cond
+	perProvider int
+
+	providers []string
+	queues    map[string][]*task
+	running   map[string]int
+	cursor    int
+	closed    bool
+}
+
+func newScheduler(perProvider int) *scheduler {
+	s := &scheduler{
+		perProvider: perProvider,
+		queues:      map[string][]*task{},
+		running:     map[string]int{},
+	}
+	s.cond = sync.NewCond(&s.lk)
+	return s
+}
+
+func (s *scheduler) push(t *task) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	p := t.provider.String()
+	if _, ok := s.queues[p]; !ok {
+		s.providers = append(s.providers, p)
+	}
+	s.queues[p] = append(s.queues[p], t)
+	s.cond.Broadcast()
+}
+
+// close tells no more tasks will be pushed
+func (s *scheduler) close() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// pop blocks until a task of a provider under its cap is available, it returns
+// false when all the tasks are handed out or ctx is done
+func (s *scheduler) pop(ctx context.Context) (*task, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	for {
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		if t := s.next(); t != nil {
+			return t, true
+		}
+		if s.closed && s.pending() == 0 {
+			return nil, false
+		}
+		s.cond.Wait()
+	}
+}
+
+// done releases the provider slot of the task
+func (s *scheduler) done(t *task) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.running[t.provider.String()]--
+	s.cond.Broadcast()
+}
+
+// wake unblocks the pops when ctx is done
+func (s *scheduler) wake(ctx context.Context) func() bool {
+	return context.AfterFunc(ctx, func() {
+		s.lk.Lock()
+		defer s.lk.Unlock()
+		s.cond.Broadcast()
+	})
+}
+
+func (s *scheduler) next() *task {
+	for i := 0; i < len(s.providers); i++ {
+		idx := (s.cursor + i) % len(s.providers)
+		p := s.providers[idx]
+		q := s.queues[p]
+		if len(q) == 0 {
+			continue
+		}
+		if s.perProvider > 0 && s.running[p] >= s.perProvider {
+			continue
+		}
+		s.queues[p] = q[1:]
+		s.running[p]++
+		s.cursor = idx + 1
+		return q[0]
+	}
+	return nil
+}
+
+func (s *scheduler) pending() int {
+	n := 0
+	for _, q := range s.queues {
+		n += len(q)
+	}
+	return n
+}
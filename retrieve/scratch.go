@@ -0,0 +1,61 @@
+package retrieve
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	ltypes "github.com/filecoin-project/lassie/pkg/types"
+)
+
+// fetchStore is where a fetch keeps the blocks, removed when closed
+type fetchStore interface {
+	ltypes.ReadableWritableStorage
+	io.Closer
+}
+
+// scratch is the dir of the blocks being fetched, shared by all the fetches
+// and capped to limit bytes, zero means no cap
+type scratch struct {
+	dir string
+
+	lk    sync.Mutex
+	limit int64
+	used  int64
+}
+
+func newScratch(dir string, limit int64) (*scratch, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &scratch{dir: dir, limit: limit}, nil
+}
+
+func (s *scratch) setLimit(limit int64) {
+	s.lk.Lock()
+	s.limit = limit
+	s.lk.Unlock()
+}
+
+// reserve books n bytes, it fails if the scratch dir would go over the limit
+func (s *scratch) reserve(n int64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.limit > 0 && s.used+n > s.limit {
+		return fmt.Errorf("scratch dir full: %d of %d bytes used", s.used, s.limit)
+	}
+	s.used += n
+	return nil
+}
+
+func (s *scratch) release(n int64) {
+	s.lk.Lock()
+	s.used -= n
+	s.lk.Unlock()
+}
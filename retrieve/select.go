@@ -0,0 +1,228 @@
+package retrieve
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/ipfs/go-cid"
+)
+
+// DealFilter selects deals without SQL, its conditions are and-ed with the providers of the run
+type DealFilter struct {
+	Clients []string `json:"clients"`
+	// FailedSince selects the deals whose last test failed after it, a UTC time
+	// ("2006-01-02 15:04:05" or "2006-01-02") or a duration ago ("12h")
+	FailedSince string `json:"failedSince"`
+	NeverTested bool   `json:"neverTested"`
+}
+
+// explicit tells whether the run targets given deals instead of sampling each provider
+func (mp *ManualParam) explicit() bool {
+	return len(mp.DealIDs) > 0 || len(mp.PayloadCIDs) > 0 || mp.Filter != nil
+}
+
+// readDealList adds the deals of a list to the param, one deal ID or payload CID per line
+func readDealList(rd io.Reader, mp *ManualParam) error {
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if id, err := strconv.ParseInt(line, 10, 64); err == nil {
+			mp.DealIDs = append(mp.DealIDs, id)
+			continue
+		}
+		if _, err := cid.Parse(line); err != nil {
+			return fmt.Errorf("invalid line: %s", line)
+		}
+		mp.PayloadCIDs = append(mp.PayloadCIDs, line)
+	}
+	return scanner.Err()
+}
+
+// parseSince parses a time or a duration ago
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed since: invalid time or duration: %s", s)
+}
+
+// maxVars is the size of the chunks of the deal IDs and payload CIDs bound in a query,
+// well under the 32766 variables SQLite accepts
+const maxVars = 1000
+
+// selectTasks returns the tasks of the deals given by ID, and of the deals of the payloads
+// or of the filter, which are restricted to the providers of the param if any, up to
+// the limit of the param if any
+func (r *Retrieve) selectTasks(ctx context.Context, mp *ManualParam) ([]*task, error) {
+	tasks := []*task{}
+	seen := map[int64]bool{}
+	full := func() bool {
+		return mp.Limit > 0 && len(tasks) >= mp.Limit
+	}
+	add := func(query string, args ...any) error {
+		if mp.Limit > 0 {
+			query += fmt.Sprintf(" LIMIT %d", mp.Limit-len(tasks))
+		}
+		ts, err := r.queryTasks(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for _, t := range ts {
+			if !seen[t.dealID] && !full() {
+				seen[t.dealID] = true
+				tasks = append(tasks, t)
+			}
+		}
+		return nil
+	}
+
+	for _, ids := range chunks(mp.DealIDs, maxVars) {
+		if full() {
+			break
+		}
+		args := []any{}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		err := add(`SELECT deal_id,payload_cid,provider FROM Deals WHERE deal_id IN (`+placeholders(len(args))+`) ORDER BY deal_id`, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(mp.DealIDs) > 0 && len(seen) < len(mp.DealIDs) && !full() {
+		log.Warnw("unknown deals skipped", "asked", len(mp.DealIDs), "found", len(seen))
+	}
+
+	if len(mp.PayloadCIDs) == 0 && mp.Filter == nil {
+		return tasks, nil
+	}
+
+	conds := []string{}
+	args := []any{}
+	in := func(column string, values []string) {
+		conds = append(conds, column+` IN (`+placeholders(len(values))+`)`)
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	if len(mp.Providers) > 0 {
+		in("provider", mp.Providers)
+	}
+	if f := mp.Filter; f != nil {
+		head, err := r.lotusApi.ChainHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// like the strategies, skip the deals known to be slashed, expired or never activated
+		conds = append(conds, `(slash_epoch IS NULL OR slash_epoch = -1) AND (sector_start_epoch IS NULL OR sector_start_epoch != -1) AND end_epoch > ?`)
+		args = append(args, head.Height())
+
+		if len(f.Clients) > 0 {
+			in("client", f.Clients)
+		}
+		if f.FailedSince != "" {
+			since, err := parseSince(f.FailedSince)
+			if err != nil {
+				return nil, err
+			}
+			conds = append(conds, `last_update >= ? AND `+repo.Failed)
+			args = append(args, since.UTC().Format(time.DateTime))
+		}
+		if f.NeverTested {
+			conds = append(conds, `last_update IS NULL`)
+		}
+	}
+
+	if len(mp.PayloadCIDs) == 0 {
+		err := add(`SELECT deal_id,payload_cid,provider FROM Deals WHERE `+strings.Join(conds, " AND ")+` ORDER BY deal_id`, args...)
+		if err != nil {
+			return nil, err
+		}
+		return tasks, nil
+	}
+	for _, payloads := range chunks(mp.PayloadCIDs, maxVars) {
+		if full() {
+			break
+		}
+		pargs := slices.Clone(args)
+		for _, p := range payloads {
+			pargs = append(pargs, p)
+		}
+		pconds := append(slices.Clip(conds), `payload_cid IN (`+placeholders(len(payloads))+`)`)
+		err := add(`SELECT deal_id,payload_cid,provider FROM Deals WHERE `+strings.Join(pconds, " AND ")+` ORDER BY deal_id`, pargs...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// chunks splits s in slices of n elements at most
+func chunks[T any](s []T, n int) [][]T {
+	var cs [][]T
+	for len(s) > n {
+		cs = append(cs, s[:n:n])
+		s = s[n:]
+	}
+	if len(s) > 0 {
+		cs = append(cs, s)
+	}
+	return cs
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func (r *Retrieve) queryTasks(ctx context.Context, query string, args ...any) ([]*task, error) {
+	rows, err := r.repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []*task{}
+	for rows.Next() {
+		var dealID int64
+		var payloadCID, provider string
+
+		err := rows.Scan(&dealID, &payloadCID, &provider)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := cid.Parse(payloadCID)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		addr, err := address.NewFromString(provider)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		tasks = append(tasks, &task{
+			dealID:     dealID,
+			payloadCID: payload,
+			provider:   addr,
+		})
+	}
+
+	return tasks, rows.Err()
+}
@@ -0,0 +1,39 @@
+package retrieve
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelectManyDeals(t *testing.T) {
+	rt := newTestRetrieve(t, &fetches{errs: []error{nil}})
+	defer rt.Shutdown(context.Background())
+
+	// more deal IDs than SQLite binds in a query
+	const deals = 40000
+	_, err := rt.repo.DB.Exec(`WITH RECURSIVE ids(id) AS (SELECT 2 UNION ALL SELECT id+1 FROM ids WHERE id < $1)
+		INSERT INTO Deals (deal_id, payload_cid, provider) SELECT id, payload_cid, provider FROM ids, (SELECT payload_cid, provider FROM Deals WHERE deal_id=1)`, deals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	for id := int64(1); id <= deals; id++ {
+		ids = append(ids, id)
+	}
+
+	tasks, err := rt.selectTasks(context.Background(), &ManualParam{DealIDs: ids})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != deals {
+		t.Fatalf("tasks: got %d, want %d", len(tasks), deals)
+	}
+
+	tasks, err = rt.selectTasks(context.Background(), &ManualParam{DealIDs: ids, Limit: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1500 {
+		t.Fatalf("tasks with a limit: got %d, want 1500", len(tasks))
+	}
+}
@@ -0,0 +1,55 @@
+package retrieve
+
+import (
+	"fmt"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// task selection strategies
+const (
+	strategyRandom = "random"
+	// deals never tested first, then random
+	strategyNeverTested = "never-tested"
+	// deals tested the longest time ago first, never tested ones before all
+	strategyLeastRecent = "least-recent"
+	// deals failed on their last test, most recent first
+	strategyRecentFailed = "recent-failed"
+	// deals activated most recently first
+	strategyNewlyActivated = "newly-activated"
+	// deals spread evenly across the clients of the provider
+	strategyByClient = "by-client"
+)
+
+// selectable skips the deals which are known to be slashed, expired or never activated,
+// $1 is the provider and $2 the current height
+const selectable = `provider=$1 AND (slash_epoch IS NULL OR slash_epoch = -1) AND (sector_start_epoch IS NULL OR sector_start_epoch != -1) AND end_epoch > $2`
+
+// queries select at most $3 deals of a provider
+var strategies = map[string]string{
+	strategyRandom: `SELECT deal_id,payload_cid,provider FROM Deals WHERE ` + selectable + `
+		ORDER BY RANDOM() LIMIT $3`,
+	strategyNeverTested: `SELECT deal_id,payload_cid,provider FROM Deals WHERE ` + selectable + `
+		ORDER BY last_update IS NOT NULL, RANDOM() LIMIT $3`,
+	strategyLeastRecent: `SELECT deal_id,payload_cid,provider FROM Deals WHERE ` + selectable + `
+		ORDER BY last_update IS NOT NULL, last_update ASC, RANDOM() LIMIT $3`,
+	strategyRecentFailed: `SELECT deal_id,payload_cid,provider FROM Deals WHERE ` + selectable + `
+		AND last_update IS NOT NULL AND ` + repo.Failed + `
+		ORDER BY last_update DESC LIMIT $3`,
+	strategyNewlyActivated: `SELECT deal_id,payload_cid,provider FROM Deals WHERE ` + selectable + `
+		ORDER BY COALESCE(sector_start_epoch, start_epoch) DESC LIMIT $3`,
+	strategyByClient: `SELECT deal_id,payload_cid,provider FROM (
+			SELECT deal_id,payload_cid,provider, ROW_NUMBER() OVER (PARTITION BY client ORDER BY RANDOM()) AS n FROM Deals WHERE ` + selectable + `
+		) ORDER BY n, RANDOM() LIMIT $3`,
+}
+
+func strategyQuery(strategy string) (string, error) {
+	if strategy == "" {
+		strategy = strategyRandom
+	}
+	q, ok := strategies[strategy]
+	if !ok {
+		return "", fmt.Errorf("unknown strategy: %s", strategy)
+	}
+	return q, nil
+}
@@ -0,0 +1,215 @@
+package status
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/gh-efforts/rbot/build"
+	"github.com/gh-efforts/rbot/node"
+	"github.com/gh-efforts/rbot/onchain"
+	"github.com/gh-efforts/rbot/repo"
+	"github.com/gh-efforts/rbot/retrieve"
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var log = logging.Logger("status")
+
+const (
+	// the bot is not ready when the lotus head is older
+	maxHeadLag   = 5 * time.Minute
+	checkTimeout = 10 * time.Second
+)
+
+type Status struct {
+	repo    *repo.Repo
+	pool    *node.Pool
+	onchain *onchain.OnChain
+	rt      *retrieve.Retrieve
+	started time.Time
+	// set once the shutdown begins, the bot is no longer ready
+	shuttingDown atomic.Bool
+}
+
+type DBStatus struct {
+	OK    bool   `json:"ok"`
+	Deals int64  `json:"deals"`
+	Err   string `json:"err,omitempty"`
+}
+
+type LotusStatus struct {
+	OK        bool              `json:"ok"`
+	Height    abi.ChainEpoch    `json:"height"`
+	HeadTime  time.Time         `json:"headTime"`
+	Lag       string            `json:"lag"`
+	LagEpochs int64             `json:"lagEpochs"`
+	Err       string            `json:"err,omitempty"`
+	Nodes     []node.NodeStatus `json:"nodes"`
+}
+
+type RunStatus struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Tasks      int64     `json:"tasks"`
+	Result     string    `json:"result"`
+	ErrMsg     string    `json:"errMsg,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Report is the status of each subsystem, Problems tells why the bot is not ready
+type Report struct {
+	Version     string             `json:"version"`
+	Uptime      string             `json:"uptime"`
+	Ready       bool               `json:"ready"`
+	Problems    []string           `json:"problems"`
+	DB          DBStatus           `json:"db"`
+	Lotus       LotusStatus        `json:"lotus"`
+	OnChain     onchain.Status     `json:"onchain"`
+	Running     []retrieve.RunInfo `json:"running"`
+	LastRun     *RunStatus         `json:"lastRun"`
+	LastCronRun *RunStatus         `json:"lastCronRun"`
+}
+
+func New(repo *repo.Repo, pool *node.Pool, oc *onchain.OnChain, rt *retrieve.Retrieve) *Status {
+	return &Status{
+		repo:    repo,
+		pool:    pool,
+		onchain: oc,
+		rt:      rt,
+		started: time.Now(),
+	}
+}
+
+func (s *Status) report(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	rp := &Report{
+		Version:  build.UserVersion(),
+		Uptime:   time.Since(s.started).Round(time.Second).String(),
+		Problems: []string{},
+		DB:       s.db(ctx),
+		Lotus:    s.lotus(ctx),
+		OnChain:  s.onchain.Status(),
+		Running:  s.rt.Running(),
+	}
+
+	var err error
+	rp.LastRun, err = s.lastRun(ctx, "")
+	if err != nil {
+		log.Warnw("last run", "err", err)
+	}
+	rp.LastCronRun, err = s.lastRun(ctx, retrieve.RunCron)
+	if err != nil {
+		log.Warnw("last cron run", "err", err)
+	}
+
+	if s.shuttingDown.Load() {
+		rp.Problems = append(rp.Problems, "shutting down")
+	}
+	if !rp.DB.OK {
+		rp.Problems = append(rp.Problems, "db: "+rp.DB.Err)
+	}
+	if !rp.Lotus.OK {
+		rp.Problems = append(rp.Problems, "lotus: "+rp.Lotus.Err)
+	}
+	if !rp.OnChain.Subscribed {
+		rp.Problems = append(rp.Problems, "onchain: not subscribed to the deal events")
+	}
+	rp.Ready = len(rp.Problems) == 0
+	return rp
+}
+
+func (s *Status) db(ctx context.Context) DBStatus {
+	var st DBStatus
+	err := s.repo.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM Deals`).Scan(&st.Deals)
+	if err != nil {
+		st.Err = err.Error()
+		return st
+	}
+	st.OK = true
+	return st
+}
+
+func (s *Status) lotus(ctx context.Context) LotusStatus {
+	st := LotusStatus{Nodes: s.pool.Status()}
+	head, err := s.pool.ChainHead(ctx)
+	if err != nil {
+		st.Err = err.Error()
+		return st
+	}
+	st.Height = head.Height()
+	st.HeadTime = time.Unix(int64(head.MinTimestamp()), 0)
+	lag := time.Since(st.HeadTime)
+	st.Lag = lag.Round(time.Second).String()
+	st.LagEpochs = int64(lag / (builtin.EpochDurationSeconds * time.Second))
+	if lag > maxHeadLag {
+		st.Err = fmt.Sprintf("head %d is %s behind", st.Height, st.Lag)
+		return st
+	}
+	st.OK = true
+	return st
+}
+
+// lastRun returns the last finished run of the kind, or of any kind if empty, nil if none
+func (s *Status) lastRun(ctx context.Context, kind string) (*RunStatus, error) {
+	var rs RunStatus
+	var errMsg sql.NullString
+	err := s.repo.DB.QueryRowContext(ctx, `SELECT run_id, kind, COALESCE(tasks, 0), COALESCE(result, ''), err_msg, started_at, finished_at FROM Runs
+		WHERE finished_at IS NOT NULL AND ($1 = '' OR kind = $1) ORDER BY run_id DESC LIMIT 1`, kind).
+		Scan(&rs.ID, &rs.Kind, &rs.Tasks, &rs.Result, &errMsg, &rs.StartedAt, &rs.FinishedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rs.ErrMsg = errMsg.String
+	return &rs, nil
+}
+
+// ShuttingDown makes the readiness probe fail for the rest of the shutdown
+func (s *Status) ShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+// Healthz is the liveness probe, it fails only if the DB can not be queried
+func (s *Status) Healthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	err := s.repo.DB.PingContext(ctx)
+	if err != nil {
+		http.Error(w, "db: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz is the readiness probe, it fails if the DB, lotus or the events subscription is down
+func (s *Status) Readyz(w http.ResponseWriter, r *http.Request) {
+	rp := s.report(r.Context())
+	if !rp.Ready {
+		http.Error(w, strings.Join(rp.Problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Status serves the Report of every subsystem as JSON, 503 if the bot is not ready
+func (s *Status) Status(w http.ResponseWriter, r *http.Request) {
+	rp := s.report(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !rp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rp)
+}
@@ -16,7 +16,7 @@ var tmplFS embed.FS
 
 var log = logging.Logger("web")
 
-var tmpl = template.Must(template.ParseFS(tmplFS, "templates/index.html"))
+var tmpl = template.Must(template.ParseFS(tmplFS, "templates/*.html"))
 
 type Web struct {
 	repo *repo.Repo
@@ -30,9 +30,50 @@ type Deal struct {
 	StartEpoch    int
 	EndEpoch      int
 	IndexerResult string
+	IndexerPeers  string
 	FetchResult   string
 	ErrMsg        string
+	DirectResult  string
 	LastUpdate    string
+	Candidates    int
+}
+
+type Candidate struct {
+	PeerID      string
+	Addrs       string
+	Protocols   string
+	Metadata    string
+	MetadataErr string
+	FetchResult string
+	ErrMsg      string
+	LastUpdate  string
+}
+
+type IndexerResult struct {
+	Indexer    string
+	Result     string
+	Records    int
+	ErrMsg     string
+	LastUpdate string
+}
+
+type Attempt struct {
+	RunID         int64
+	Attempt       int
+	IndexerResult string
+	FetchResult   string
+	DirectResult  string
+	ErrClass      string
+	ErrMsg        string
+	StartedAt     string
+	DurationMs    int64
+}
+
+type CandidatesData struct {
+	DealID     int
+	Indexers   []IndexerResult
+	Candidates []Candidate
+	Attempts   []Attempt
 }
 
 type PageData struct {
@@ -63,7 +104,7 @@ func (e *Web) Index(w http.ResponseWriter, r *http.Request) {
 	}
 	offset := (pageNum - 1) * pageSize
 
-	query := "SELECT deal_id, payload_cid, client, provider, start_epoch, end_epoch, indexer_result, fetch_result, err_msg, last_update FROM Deals WHERE 1=1"
+	query := "SELECT deal_id, payload_cid, client, provider, start_epoch, end_epoch, indexer_result, indexer_peers, fetch_result, err_msg, direct_result, direct_protocols, direct_err_msg, last_update, (SELECT COUNT(*) FROM Candidates c WHERE c.deal_id = Deals.deal_id) FROM Deals WHERE 1=1"
 	if client != "" {
 		query += " AND client LIKE '%" + client + "%'"
 	}
@@ -81,17 +122,25 @@ func (e *Web) Index(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	var deals []Deal
-	var indexerResult, fetchResult, errMsg, lastUpdate sql.NullString
+	var indexerResult, indexerPeers, fetchResult, errMsg, directResult, directProtocols, directErrMsg, lastUpdate sql.NullString
 	for rows.Next() {
 		var deal Deal
-		err := rows.Scan(&deal.DealID, &deal.PayloadCID, &deal.Client, &deal.Provider, &deal.StartEpoch, &deal.EndEpoch, &indexerResult, &fetchResult, &errMsg, &lastUpdate)
+		err := rows.Scan(&deal.DealID, &deal.PayloadCID, &deal.Client, &deal.Provider, &deal.StartEpoch, &deal.EndEpoch, &indexerResult, &indexerPeers, &fetchResult, &errMsg, &directResult, &directProtocols, &directErrMsg, &lastUpdate, &deal.Candidates)
 		if err != nil {
 			log.Error(err)
 			continue
 		}
 		deal.IndexerResult = indexerResult.String
+		deal.IndexerPeers = indexerPeers.String
 		deal.FetchResult = fetchResult.String
 		deal.ErrMsg = errMsg.String
+		deal.DirectResult = directResult.String
+		if directProtocols.String != "" {
+			deal.DirectResult += " (" + directProtocols.String + ")"
+		}
+		if directErrMsg.String != "" {
+			deal.DirectResult += ": " + directErrMsg.String
+		}
 		deal.LastUpdate = lastUpdate.String
 		deals = append(deals, deal)
 	}
@@ -110,5 +159,118 @@ func (e *Web) Index(w http.ResponseWriter, r *http.Request) {
 		NextPage: nextPage,
 	}
 
-	tmpl.Execute(w, data)
+	tmpl.ExecuteTemplate(w, "index.html", data)
+}
+
+func (e *Web) Candidates(w http.ResponseWriter, r *http.Request) {
+	dealID, err := strconv.Atoi(r.URL.Query().Get("dealID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := e.repo.DB.Query("SELECT peer_id, addrs, protocols, metadata, metadata_err, fetch_result, err_msg, last_update FROM Candidates WHERE deal_id = ? ORDER BY peer_id", dealID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var candidates []Candidate
+	var addrs, protocols, metadata, metadataErr, fetchResult, errMsg, lastUpdate sql.NullString
+	for rows.Next() {
+		var c Candidate
+		err := rows.Scan(&c.PeerID, &addrs, &protocols, &metadata, &metadataErr, &fetchResult, &errMsg, &lastUpdate)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		c.Addrs = addrs.String
+		c.Protocols = protocols.String
+		c.Metadata = metadata.String
+		c.MetadataErr = metadataErr.String
+		c.FetchResult = fetchResult.String
+		c.ErrMsg = errMsg.String
+		c.LastUpdate = lastUpdate.String
+		candidates = append(candidates, c)
+	}
+
+	indexers, err := e.indexerResults(dealID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := e.attempts(dealID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := CandidatesData{
+		DealID:     dealID,
+		Indexers:   indexers,
+		Candidates: candidates,
+		Attempts:   attempts,
+	}
+
+	tmpl.ExecuteTemplate(w, "candidates.html", data)
+}
+
+func (e *Web) indexerResults(dealID int) ([]IndexerResult, error) {
+	rows, err := e.repo.DB.Query("SELECT indexer, result, records, err_msg, last_update FROM IndexerResults WHERE deal_id = ?", dealID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []IndexerResult
+	var result, errMsg, lastUpdate sql.NullString
+	var records sql.NullInt64
+	for rows.Next() {
+		var ir IndexerResult
+		err := rows.Scan(&ir.Indexer, &result, &records, &errMsg, &lastUpdate)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		ir.Result = result.String
+		ir.Records = int(records.Int64)
+		ir.ErrMsg = errMsg.String
+		ir.LastUpdate = lastUpdate.String
+		results = append(results, ir)
+	}
+
+	return results, nil
+}
+
+func (e *Web) attempts(dealID int) ([]Attempt, error) {
+	rows, err := e.repo.DB.Query("SELECT run_id, attempt, indexer_result, fetch_result, direct_result, err_class, err_msg, started_at, duration_ms FROM Attempts WHERE deal_id = ? ORDER BY started_at DESC LIMIT 50", dealID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []Attempt
+	var runID, durationMs sql.NullInt64
+	var indexerResult, fetchResult, directResult, errClass, errMsg, startedAt sql.NullString
+	for rows.Next() {
+		var a Attempt
+		err := rows.Scan(&runID, &a.Attempt, &indexerResult, &fetchResult, &directResult, &errClass, &errMsg, &startedAt, &durationMs)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		a.RunID = runID.Int64
+		a.IndexerResult = indexerResult.String
+		a.FetchResult = fetchResult.String
+		a.DirectResult = directResult.String
+		a.ErrClass = errClass.String
+		a.ErrMsg = errMsg.String
+		a.StartedAt = startedAt.String
+		a.DurationMs = durationMs.Int64
+		attempts = append(attempts, a)
+	}
+
+	return attempts, nil
 }
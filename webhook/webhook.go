@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gh-efforts/rbot/repo"
+)
+
+// formats of the payload
+const (
+	// {"text": ...}, understood by Slack and most chat incoming webhooks
+	FormatSlack = "slack"
+	// the whole message as JSON
+	FormatJSON = "json"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Post sends the message to the webhook, as text or as it is depending on the format
+func Post(ctx context.Context, wh repo.Webhook, text string, msg any) error {
+	var payload any
+	switch wh.Format {
+	case FormatSlack, "":
+		payload = map[string]string{"text": text}
+	case FormatJSON:
+		payload = msg
+	default:
+		return fmt.Errorf("unknown webhook format: %s", wh.Format)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		r, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status: %s msg: %s", resp.Status, string(r))
+	}
+	return nil
+}